@@ -3,6 +3,7 @@ module github.com/librun/migrago
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/ClickHouse/clickhouse-go v1.4.1
 	github.com/boltdb/bolt v1.3.1
 	github.com/go-sql-driver/mysql v1.5.0
@@ -0,0 +1,224 @@
+package action
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of report files for CI systems.
+const (
+	ReportJUnit = "junit"
+	ReportTAP   = "tap"
+)
+
+// databaseCase is a name of the test case of a database error which is not an
+// error of a migration, for example a connection error.
+const databaseCase = "database"
+
+// ReportFile is a file the report is written to in the format.
+type ReportFile struct {
+	Format string
+	Path   string
+}
+
+// ParseReportFile parses a report file option: junit=<file> or tap=<file>.
+func ParseReportFile(option string) (ReportFile, error) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return ReportFile{}, fmt.Errorf("invalid report %s, expected junit=<file> or tap=<file>", option)
+	}
+
+	if parts[0] != ReportJUnit && parts[0] != ReportTAP {
+		return ReportFile{}, fmt.Errorf("unsupported report format %s (junit or tap)", parts[0])
+	}
+
+	return ReportFile{Format: parts[0], Path: parts[1]}, nil
+}
+
+// WriteFile writes the report to the file. Every migration of every database is
+// a test case, pending migrations are skipped. Name is a name of the run.
+func (r Report) WriteFile(file ReportFile, name string) error {
+	f, err := os.Create(file.Path)
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+
+	if file.Format == ReportTAP {
+		err = r.writeTAP(w)
+	} else {
+		err = r.writeJUnit(w, name)
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+
+	if err != nil {
+		return fmt.Errorf("write report %s: %w", file.Path, err)
+	}
+
+	return nil
+}
+
+type (
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Skipped  int          `xml:"skipped,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+
+	junitSuite struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Skipped  int         `xml:"skipped,attr"`
+		Time     string      `xml:"time,attr"`
+		Cases    []junitCase `xml:"testcase"`
+	}
+
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure"`
+		Skipped   *junitSkipped `xml:"skipped"`
+	}
+
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+
+	junitSkipped struct {
+		Message string `xml:"message,attr"`
+	}
+)
+
+func (r Report) writeJUnit(w io.Writer, name string) error {
+	suites := junitSuites{Name: name}
+
+	var total int64
+
+	for _, db := range r.Databases {
+		suite := junitSuite{Name: db.Project + "/" + db.Database, Time: seconds(db.DurationMs)}
+		total += db.DurationMs
+
+		for _, c := range db.cases() {
+			jc := junitCase{Name: c.name, ClassName: suite.Name, Time: seconds(c.durationMs)}
+
+			switch {
+			case c.skipped:
+				jc.Skipped = &junitSkipped{Message: c.message}
+				suite.Skipped++
+			case c.message != "":
+				jc.Failure = &junitFailure{Message: c.message, Text: c.message}
+				suite.Failures++
+			}
+
+			suite.Cases = append(suite.Cases, jc)
+			suite.Tests++
+		}
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	suites.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+func (r Report) writeTAP(w io.Writer) error {
+	var lines []string
+
+	for _, db := range r.Databases {
+		for _, c := range db.cases() {
+			n := strconv.Itoa(len(lines) + 1)
+			desc := db.Project + "/" + db.Database + " " + c.name
+
+			switch {
+			case c.skipped:
+				lines = append(lines, "ok "+n+" - "+desc+" # SKIP "+c.message)
+			case c.message != "":
+				lines = append(lines, "not ok "+n+" - "+desc+"\n  ---\n  message: "+strconv.Quote(c.message)+
+					"\n  duration_ms: "+strconv.FormatInt(c.durationMs, 10)+"\n  ...")
+			default:
+				lines = append(lines, "ok "+n+" - "+desc+" # time="+strconv.FormatInt(c.durationMs, 10)+"ms")
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "TAP version 13\n1..%d\n%s", len(lines), strings.Join(append(lines, ""), "\n"))
+
+	return err
+}
+
+// testCase is a migration of the report as a test case.
+type testCase struct {
+	name       string
+	durationMs int64
+	// message is the error of a failed case or the reason of a skipped one.
+	message string
+	skipped bool
+}
+
+// cases returns executed migrations, the database error which is not an error
+// of a migration and pending migrations.
+func (r *DBReport) cases() []testCase {
+	cases := make([]testCase, 0, len(r.Migrations)+len(r.Pending)+1)
+
+	for _, m := range r.Migrations {
+		cases = append(cases, testCase{name: m.Version, durationMs: m.DurationMs, message: m.Error})
+	}
+
+	if r.Error != "" && r.Failed == "" {
+		cases = append(cases, testCase{name: databaseCase, message: r.Error})
+	}
+
+	reason := "not run"
+	if r.Status == StatusSkipped {
+		reason = "database skipped"
+	}
+
+	for _, version := range r.Pending {
+		cases = append(cases, testCase{name: version, message: reason, skipped: true})
+	}
+
+	return cases
+}
+
+// seconds formats milliseconds as seconds for JUnit.
+func seconds(ms int64) string {
+	return strconv.FormatFloat((time.Duration(ms) * time.Millisecond).Seconds(), 'f', 3, 64)
+}
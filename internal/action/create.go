@@ -6,100 +6,207 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/librun/migrago/internal/config"
-	"gopkg.in/yaml.v2"
+	"github.com/librun/migrago/internal/migration"
 )
 
 // Migrations create modes.
 const (
-	CreateModeUp   = "up"
-	CreateModeDown = "down"
-	CreateModeBoth = "both"
+	CreateModeUp     = "up"
+	CreateModeDown   = "down"
+	CreateModeBoth   = "both"
+	CreateModeSingle = "single"
 )
 
-// DefaultMigrationNameFormat contains template for create migration name.
-const DefaultMigrationNameFormat = "%d%02d%02d_%02d%02d%02d_%s_%s.sql"
+// Created is a result of the create command.
+type Created struct {
+	Version string   `json:"version"`
+	Files   []string `json:"files"`
+}
 
-// MakeCreate creates new migration file.
-func MakeCreate(cfgPath, name, mode, project, db string) error {
+// MakeCreate creates new migration file from a template. If mode is empty, both
+// files are created (or a single file if the project naming supports single files only).
+func MakeCreate(cfgPath, name, mode, project, db, author string) (Created, error) {
 	cfg := config.YAMLConfig{}
 
-	configFile, err := os.Open(cfgPath)
-	if err != nil {
-		return fmt.Errorf("config open: %w", err)
+	if err := config.Decode(cfgPath, &cfg); err != nil {
+		return Created{}, err
+	}
+
+	var (
+		naming config.Naming
+		paths  []string
+	)
+
+	for projectName, value := range cfg.Projects {
+		if projectName != project {
+			continue
+		}
+
+		naming = value.Naming
+
+		// The same database can be listed several times, its directories are merged.
+		for _, migrations := range value.Migrations {
+			paths = append(paths, migrations[db]...)
+		}
+	}
+
+	if len(paths) == 0 {
+		return Created{}, errors.New("invalid project or db")
 	}
 
-	defer configFile.Close()
+	// New migrations are created in the first directory of the database.
+	directory := paths[0]
 
-	configByte, err := ioutil.ReadAll(configFile)
+	// A pattern is resolved to its first directory, only a plain path is created.
+	if strings.ContainsAny(directory, "*?[") {
+		dirs, err := globDirs([]string{directory})
+		if err != nil {
+			return Created{}, err
+		}
+
+		if len(dirs) == 0 {
+			return Created{}, fmt.Errorf("directory pattern %s matches no directory to create the migration in", directory)
+		}
+
+		directory = dirs[0]
+	}
+
+	if _, err := os.Stat(directory); os.IsNotExist(err) {
+		if err = os.MkdirAll(directory, 0777); err != nil {
+			return Created{}, fmt.Errorf("create directory %s error: %w", directory, err)
+		}
+	}
+
+	scheme, err := migration.NewScheme(naming)
 	if err != nil {
-		return fmt.Errorf("config read: %w", err)
+		return Created{}, fmt.Errorf("project %s: %w", project, err)
 	}
 
-	if err := yaml.Unmarshal(configByte, &cfg); err != nil {
-		return fmt.Errorf("config format: %w", err)
+	// Sequence versions follow the largest version among existing migrations.
+	var existing []migration.File
+
+	if scheme.Sequence() {
+		if existing, err = scanExisting(paths, scheme); err != nil {
+			return Created{}, err
+		}
 	}
 
-	// Get migration directory.
-	directory := ""
+	// Up and down files of one migration share the version.
+	now := time.Now()
+	version := scheme.NewVersion(now, existing)
 
-	for projectName, value := range cfg.Projects {
-		if projectName != project {
-			continue
+	if mode == "" {
+		mode = CreateModeBoth
+		if !scheme.SupportSeparate() {
+			mode = CreateModeSingle
 		}
+	}
 
-		for _, migrations := range value.Migrations {
-			var ok bool
+	// File names by template mode.
+	files := map[string]string{}
 
-			if directory, ok = migrations[db]; !ok {
-				continue
-			} else {
-				break
-			}
+	switch {
+	case mode == CreateModeSingle:
+		if !scheme.SupportSingle() {
+			return Created{}, fmt.Errorf("project %s naming does not support single files", project)
 		}
+
+		files[CreateModeSingle] = scheme.SingleFileName(version, name)
+	case !scheme.SupportSeparate():
+		return Created{}, fmt.Errorf("project %s naming supports single files only", project)
+	case mode == CreateModeBoth:
+		files[CreateModeUp] = scheme.FileName(version, name, false)
+		files[CreateModeDown] = scheme.FileName(version, name, true)
+	default:
+		files[mode] = scheme.FileName(version, name, mode == CreateModeDown)
 	}
 
-	if directory == "" {
-		return errors.New("invalid project or db")
+	templates := newMigrationTemplates(&cfg, project, cfg.Databases[db].Type)
+	data := TemplateData{
+		Name:         name,
+		Version:      version,
+		Project:      project,
+		Database:     db,
+		DatabaseType: cfg.Databases[db].Type,
+		Author:       author,
+		Date:         now,
 	}
 
-	if _, err := os.Stat(directory); os.IsNotExist(err) {
-		if err = os.MkdirAll(directory, 0777); err != nil {
-			return fmt.Errorf("create directory %s error: %w", directory, err)
+	// Render all templates before creating files, so a broken template leaves no files.
+	contents := map[string]string{}
+
+	for fileMode := range files {
+		content, err := templates.render(fileMode, data)
+		if err != nil {
+			return Created{}, fmt.Errorf("%s template: %w", fileMode, err)
 		}
+
+		contents[fileMode] = content
 	}
 
-	// Create file.
-	if mode == "both" {
-		for _, modeItem := range []string{"up", "down"} {
-			if err := createFile(name, modeItem, directory); err != nil {
-				return err
+	created := Created{Version: version}
+
+	// Create files in a fixed order: up, down, single.
+	for _, fileMode := range []string{CreateModeUp, CreateModeDown, CreateModeSingle} {
+		if filename, ok := files[fileMode]; ok {
+			if err := createFile(filename, directory, contents[fileMode]); err != nil {
+				return created, err
 			}
+
+			created.Files = append(created.Files, filepath.Join(directory, filename))
 		}
+	}
+
+	return created, nil
+}
 
-		return nil
+// Table returns the created files.
+func (c Created) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(c.Files))
+	for _, file := range c.Files {
+		rows = append(rows, []string{c.Version, file})
 	}
 
-	return createFile(name, mode, directory)
+	return []string{"version", "file"}, rows
 }
 
-func createFile(name, mode, directory string) error {
-	now := time.Now()
-	filename := fmt.Sprintf(
-		DefaultMigrationNameFormat,
-		now.Year(),
-		now.Month(),
-		now.Day(),
-		now.Hour(),
-		now.Minute(),
-		now.Second(),
-		name,
-		mode,
-	)
+// scanExisting returns migrations from existing directories (or glob patterns) of the database.
+func scanExisting(paths []string, scheme *migration.Scheme) ([]migration.File, error) {
+	dirs, err := globDirs(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return migration.ScanAll(dirs, scheme)
+}
+
+// globDirs returns existing directories matching the paths or glob patterns.
+func globDirs(paths []string) ([]string, error) {
+	var dirs []string
+
+	for _, path := range paths {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("directory pattern %s: %w", path, err)
+		}
+
+		for _, match := range matches {
+			if fi, err := os.Stat(match); err == nil && fi.IsDir() {
+				dirs = append(dirs, match)
+			}
+		}
+	}
+
+	return dirs, nil
+}
 
-	_, err := os.Create(fmt.Sprintf("%s/%s", directory, filename))
+func createFile(filename, directory, content string) error {
+	err := ioutil.WriteFile(fmt.Sprintf("%s/%s", directory, filename), []byte(content), 0666)
 	if err == nil {
 		log.Printf("migration: %s created", filename)
 	}
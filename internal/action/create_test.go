@@ -0,0 +1,46 @@
+package action
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateSequenceMergedDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrago-create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base, service := filepath.Join(dir, "base"), filepath.Join(dir, "service")
+
+	for _, d := range []string{base, service} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(service, "000003_users_up.sql"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is listed twice, the second entry has the largest version.
+	cfgPath := filepath.Join(dir, "config.yaml")
+	cfg := fmt.Sprintf("projects:\n  p:\n    naming:\n      sequence: true\n    migrations:\n    - db: %s\n    - db: %s\n"+
+		"databases:\n  db:\n    type: postgres\n", base, service)
+
+	if err := ioutil.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MakeCreate(cfgPath, "orders", CreateModeUp, "p", "db", "author"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(base, "000004_orders_up.sql")); err != nil {
+		t.Errorf("new migration is not numbered after the other directory: %v", err)
+	}
+}
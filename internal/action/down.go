@@ -1,75 +1,176 @@
 package action
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/librun/migrago/internal/config"
 	"github.com/librun/migrago/internal/database"
+	"github.com/librun/migrago/internal/migration"
 	"github.com/librun/migrago/internal/storage"
 )
 
-// MakeDown reverts migrations.
-func MakeDown(mStorage storage.Storage, cfgPath, projectName, dbName string, rollbackCount int, skipNoRollback bool) error {
+// DownOptions contains options of reverting migrations.
+type DownOptions struct {
+	Project  string
+	Database string
+	// Limit is a number of migrations to revert.
+	Limit int
+	// SkipNoRollback skips migrations without down files.
+	SkipNoRollback bool
+	// ToolVersion is the migrago version saved in history events.
+	ToolVersion string
+	// MigrationTimeout limits the execution of each migration, zero means no limit.
+	MigrationTimeout time.Duration
+}
+
+// MakeDown reverts migrations. If the context is done, the running migration is
+// rolled back and the report of reverted and not reverted migrations is logged.
+// The report is returned with the error of the run.
+func MakeDown(ctx context.Context, mStorage storage.Storage, cfgPath string, opts DownOptions) (Report, error) {
+	projectName, dbName := opts.Project, opts.Database
+	rollbackCount, skipNoRollback := opts.Limit, opts.SkipNoRollback
+
 	cfg, err := config.NewConfig(cfgPath, []string{projectName}, []string{dbName})
 	if err != nil {
-		return fmt.Errorf("get config: %w", err)
+		return Report{}, fmt.Errorf("get config: %w", err)
 	}
 
 	project, err := cfg.GetProject(projectName)
 	if err != nil {
-		return fmt.Errorf("get project: %w", err)
+		return Report{}, fmt.Errorf("get project: %w", err)
 	}
 
 	if _, err := project.GetDB(dbName); err != nil {
-		return fmt.Errorf("get project db: %w", err)
+		return Report{}, fmt.Errorf("get project db: %w", err)
 	}
 
 	projectMigration, err := project.GetProjectMigration(dbName)
 	if err != nil {
-		return fmt.Errorf("get current migration: %w", err)
+		return Report{}, fmt.Errorf("get current migration: %w", err)
 	}
 
-	dbc, err := database.NewDB(projectMigration.Database)
+	dbc, err := database.NewDB(ctx, projectMigration.Database)
 	if err != nil {
-		return fmt.Errorf("conntect to db: %w", err)
+		return Report{}, fmt.Errorf("conntect to db: %w", err)
 	}
 	defer dbc.Close()
 
-	migrations, err := mStorage.GetLast(project.Name, dbName, skipNoRollback, &rollbackCount)
+	scheme, err := migration.NewScheme(project.Naming)
+	if err != nil {
+		return Report{}, fmt.Errorf("project %s: %w", project.Name, err)
+	}
+
+	files, err := migration.Scan(projectMigration.Paths, scheme)
 	if err != nil {
-		return fmt.Errorf("get last migration: %w", err)
+		return Report{}, err
+	}
+
+	migrations, err := getLast(ctx, mStorage, scheme, project.Name, dbName, skipNoRollback, &rollbackCount)
+	if err != nil {
+		return Report{}, fmt.Errorf("get last migration: %w", err)
 	}
 
 	if len(migrations) < rollbackCount {
-		return errors.New("Have " + strconv.Itoa(len(migrations)) + " of " + strconv.Itoa(rollbackCount) + " migration")
+		return Report{}, errors.New("Have " + strconv.Itoa(len(migrations)) + " of " + strconv.Itoa(rollbackCount) +
+			" migration")
 	}
 
+	dbReport := DBReport{Project: project.Name, Database: dbName}
+	for _, migrate := range migrations {
+		dbReport.Pending = append(dbReport.Pending, migrate.Version)
+	}
+
+	start := time.Now()
+	err = revertMigrations(ctx, mStorage, dbc, files, migrations, opts, &dbReport)
+	dbReport.DurationMs = time.Since(start).Milliseconds()
+	dbReport.finish(ctx, err)
+
+	report := Report{Databases: []DBReport{dbReport}}
+	if err != nil {
+		report.Log("reverted")
+	}
+
+	return report, err
+}
+
+// revertMigrations reverts the migrations, newest first.
+func revertMigrations(ctx context.Context, mStorage storage.Storage, dbc *database.DB, files []migration.File,
+	migrations []storage.Migrate, opts DownOptions, report *DBReport) error {
+	actor := newActor()
+
 	for _, migrate := range migrations {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %w", err)
+		}
+
+		report.Pending = report.Pending[1:]
+
+		event := &storage.Event{
+			Type:        storage.EventUnmark,
+			Project:     migrate.Project,
+			Database:    migrate.Database,
+			Version:     migrate.Version,
+			Actor:       actor,
+			ToolVersion: opts.ToolVersion,
+		}
+
+		start := time.Now()
+
 		if migrate.RollFlag {
-			downFile := projectMigration.Path + migrate.Version + migratePostfixDown
+			event.Type = storage.EventRollback
+
+			file, ok := migration.Find(files, migrate.Version)
+			if !ok || !file.Rollback() {
+				return fmt.Errorf("down file for migration %s not found", migrate.Version)
+			}
 
-			content, err := ioutil.ReadFile(downFile)
+			query, err := file.ReadDown()
 			if err != nil {
-				return fmt.Errorf("read file: %w", err)
+				return err
 			}
 
-			query := string(content)
-			if strings.TrimSpace(query) != "" {
+			if !migration.IsEmpty(query) {
 				// Executing all requests from the current file.
-				if errExec := dbc.Exec(query); errExec != nil {
+				if errExec := execMigration(ctx, dbc, query, opts.MigrationTimeout); errExec != nil {
+					report.Failed = migrate.Version
+					report.addResult(migrate.Version, start, errExec)
+
+					event.Type = storage.EventFailure
+					event.Error = errExec.Error()
+
+					resCtx, cancel := resultContext()
+					if err := addEvent(resCtx, mStorage, event, start); err != nil {
+						log.Println(err)
+					}
+					cancel()
+
 					return errExec
 				}
 			}
 		}
 
+		resCtx, cancel := resultContext()
 		migrate := migrate
-		if err := mStorage.Delete(&migrate); err != nil {
-			return fmt.Errorf("delete: %w", err)
+		err := mStorage.Delete(resCtx, &migrate)
+
+		if err == nil {
+			err = addEvent(resCtx, mStorage, event, start)
+		}
+
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("delete: %w", err)
+
+			report.Failed = migrate.Version
+			report.addResult(migrate.Version, start, err)
+
+			return err
 		}
 
 		if migrate.RollFlag {
@@ -77,6 +178,9 @@ func MakeDown(mStorage storage.Storage, cfgPath, projectName, dbName string, rol
 		} else {
 			log.Println("migration: " + migrate.Version + " (not roolback) deleted")
 		}
+
+		report.Done = append(report.Done, migrate.Version)
+		report.addResult(migrate.Version, start, nil)
 	}
 
 	return nil
@@ -0,0 +1,136 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/librun/migrago/internal/storage"
+)
+
+// History is a result of the history command and of commands which record one event.
+type History struct {
+	Events []storage.Event `json:"events"`
+}
+
+// MakeHistory shows the history log of migrations.
+func MakeHistory(ctx context.Context, mStorage storage.Storage, filter storage.EventFilter) (History, error) {
+	events, err := mStorage.GetEvents(ctx, filter)
+	if err != nil {
+		return History{}, fmt.Errorf("get events: %w", err)
+	}
+
+	log.Println("History:")
+
+	for _, e := range events {
+		t := fmt.Sprintf("%s %-8s %s/%s", time.Unix(e.Time, 0).UTC().Format(time.RFC3339), e.Type, e.Project, e.Database)
+		if e.Version != "" {
+			t += " " + e.Version
+		}
+
+		t += " by " + e.Actor
+
+		if e.ToolVersion != "" {
+			t += ", migrago: " + e.ToolVersion
+		}
+
+		if e.DurationMs > 0 {
+			t += fmt.Sprintf(", duration: %dms", e.DurationMs)
+		}
+
+		if e.Description != "" {
+			t += ", description: " + e.Description
+		}
+
+		if e.Error != "" {
+			t += ", error: " + e.Error
+		}
+
+		log.Println(t)
+	}
+
+	return History{Events: events}, nil
+}
+
+// Table returns events of the history.
+func (h History) Table() ([]string, [][]string) {
+	header := []string{"time", "type", "project", "database", "version", "actor", "duration", "error"}
+	rows := make([][]string, 0, len(h.Events))
+
+	for _, e := range h.Events {
+		rows = append(rows, []string{
+			time.Unix(e.Time, 0).UTC().Format(time.RFC3339),
+			e.Type,
+			e.Project,
+			e.Database,
+			e.Version,
+			e.Actor,
+			(time.Duration(e.DurationMs) * time.Millisecond).String(),
+			e.Error,
+		})
+	}
+
+	return header, rows
+}
+
+// currentUserHost returns the OS user and the hostname.
+func currentUserHost() (string, string) {
+	var username, host string
+
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	if h, err := os.Hostname(); err == nil {
+		host = h
+	}
+
+	return username, host
+}
+
+// newActor returns the actor of history events: user@host.
+func newActor() string {
+	username, host := currentUserHost()
+
+	return username + "@" + host
+}
+
+// newEvent returns a history event for the migration record.
+func newEvent(eventType string, m *storage.Migrate) *storage.Event {
+	return &storage.Event{
+		Type:        eventType,
+		Project:     m.Project,
+		Database:    m.Database,
+		Version:     m.Version,
+		Actor:       m.AppliedBy + "@" + m.Host,
+		ToolVersion: m.ToolVersion,
+		Description: m.Description,
+		Error:       m.Error,
+	}
+}
+
+// addEvent appends the event started at start to the history log.
+func addEvent(ctx context.Context, mStorage storage.Storage, event *storage.Event, start time.Time) error {
+	event.Time = time.Now().UTC().Unix()
+	event.DurationMs = time.Since(start).Milliseconds()
+
+	if err := mStorage.AddEvent(ctx, event); err != nil {
+		return fmt.Errorf("add event: %w", err)
+	}
+
+	return nil
+}
+
+// resultTimeout limits saving results of executed migrations.
+const resultTimeout = 30 * time.Second
+
+// resultContext returns a context for saving results of executed migrations.
+// It is not cancelled with the run: a migration which is executed or reverted
+// gets its record saved or deleted even if the run is cancelled, so a result
+// of a finished statement is not lost.
+func resultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), resultTimeout)
+}
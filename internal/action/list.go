@@ -1,32 +1,123 @@
 package action
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/migration"
 	"github.com/librun/migrago/internal/storage"
 )
 
-// MakeList shows success applied migrations.
-func MakeList(mStorage storage.Storage, cfgPath, projectName, dbName string, rollbackCount *int, skipNoRollback bool) error {
+type (
+	// DBMigrations are applied migrations of a project database, newest first.
+	DBMigrations struct {
+		Project    string            `json:"project"`
+		Database   string            `json:"database"`
+		Migrations []storage.Migrate `json:"migrations"`
+	}
+
+	// List is a result of the list command.
+	List struct {
+		Databases []DBMigrations `json:"databases"`
+	}
+)
+
+// MakeList shows success applied migrations. Verbose mode shows history details of each migration.
+// For a database with tenants migrations of every tenant matching the tenant pattern are shown.
+func MakeList(ctx context.Context, mStorage storage.Storage, cfgPath, projectName, dbName, tenant string,
+	rollbackCount *int, skipNoRollback, verbose bool) (List, error) {
 	cfg, err := config.NewConfig(cfgPath, []string{projectName}, []string{dbName})
 	if err != nil {
-		return fmt.Errorf("config open: %w", err)
+		return List{}, fmt.Errorf("config open: %w", err)
 	}
 
 	project, err := cfg.GetProject(projectName)
 	if err != nil {
-		return fmt.Errorf("get project: %w", err)
+		return List{}, fmt.Errorf("get project: %w", err)
+	}
+
+	db, err := project.GetDB(dbName)
+	if err != nil {
+		return List{}, fmt.Errorf("get project db: %w", err)
+	}
+
+	scheme, err := migration.NewScheme(project.Naming)
+	if err != nil {
+		return List{}, fmt.Errorf("project %s: %w", project.Name, err)
+	}
+
+	dbNames := []string{dbName}
+
+	if db.Tenants != nil {
+		if err := expandTenants(ctx, &cfg, tenant); err != nil {
+			return List{}, err
+		}
+
+		project, err = cfg.GetProject(projectName)
+		if err != nil {
+			return List{}, fmt.Errorf("get project: %w", err)
+		}
+
+		dbNames = dbNames[:0]
+		for _, prjMigration := range project.Migrations {
+			dbNames = append(dbNames, prjMigration.Database.Name)
+		}
+	}
+
+	list := List{Databases: make([]DBMigrations, 0, len(dbNames))}
+
+	for _, name := range dbNames {
+		if db.Tenants != nil {
+			log.Println("Tenant: " + strings.TrimPrefix(name, dbName+"/"))
+		}
+
+		migrations, err := listMigrations(ctx, mStorage, scheme, project.Name, name, rollbackCount, skipNoRollback, verbose)
+		if err != nil {
+			return list, err
+		}
+
+		list.Databases = append(list.Databases, DBMigrations{Project: project.Name, Database: name, Migrations: migrations})
 	}
 
-	if _, err := project.GetDB(dbName); err != nil {
-		return fmt.Errorf("get project db: %w", err)
+	return list, nil
+}
+
+// Table returns migrations of all databases of the list.
+func (l List) Table() ([]string, [][]string) {
+	header := []string{"project", "database", "version", "applied", "duration", "rollback", "out of order", "by"}
+
+	var rows [][]string
+
+	for _, db := range l.Databases {
+		for _, m := range db.Migrations {
+			rows = append(rows, []string{
+				m.Project,
+				m.Database,
+				m.Version,
+				time.Unix(m.ApplyTime, 0).UTC().Format(time.RFC3339),
+				(time.Duration(m.DurationMs) * time.Millisecond).String(),
+				strconv.FormatBool(m.RollFlag),
+				strconv.FormatBool(m.OutOfOrder),
+				m.AppliedBy + "@" + m.Host,
+			})
+		}
 	}
 
-	migrations, err := mStorage.GetLast(project.Name, dbName, skipNoRollback, rollbackCount)
+	return header, rows
+}
+
+// listMigrations logs the migrations of the database and returns them.
+func listMigrations(ctx context.Context, mStorage storage.Storage, scheme *migration.Scheme, projectName, dbName string,
+	rollbackCount *int, skipNoRollback, verbose bool) ([]storage.Migrate, error) {
+	migrations, err := getLast(ctx, mStorage, scheme, projectName, dbName, skipNoRollback, rollbackCount)
 	if err != nil {
-		return fmt.Errorf("get last migration: %w", err)
+		return nil, fmt.Errorf("get last migration: %w", err)
 	}
 
 	log.Println("Migrations list:")
@@ -37,8 +128,42 @@ func MakeList(mStorage storage.Storage, cfgPath, projectName, dbName string, rol
 			t += " (no rollback)"
 		}
 
+		if migrate.OutOfOrder {
+			t += " (out of order)"
+		}
+
+		if verbose {
+			t += fmt.Sprintf(" applied: %s, duration: %dms, by: %s@%s, migrago: %s, checksum: %s",
+				time.Unix(migrate.ApplyTime, 0).UTC().Format(time.RFC3339), migrate.DurationMs, migrate.AppliedBy,
+				migrate.Host, migrate.ToolVersion, migrate.Checksum)
+
+			if migrate.Description != "" {
+				t += ", description: " + migrate.Description
+			}
+		}
+
 		log.Println(t)
 	}
 
-	return nil
+	return migrations, nil
+}
+
+// getLast gets a list of recent migrations ordered by the project naming scheme.
+// Storages order versions lexicographically, so all records are sorted here.
+func getLast(ctx context.Context, mStorage storage.Storage, scheme *migration.Scheme, projectName, dbName string, skipNoRollback bool,
+	limit *int) ([]storage.Migrate, error) {
+	migrations, err := mStorage.GetLast(ctx, projectName, dbName, skipNoRollback, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return scheme.Less(migrations[j].Version, migrations[i].Version)
+	})
+
+	if limit != nil && len(migrations) > *limit {
+		migrations = migrations[:*limit]
+	}
+
+	return migrations, nil
 }
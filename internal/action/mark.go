@@ -0,0 +1,133 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/migration"
+	"github.com/librun/migrago/internal/storage"
+)
+
+// MakeMark records the migration as applied without executing it. The recorded event is returned.
+func MakeMark(ctx context.Context, mStorage storage.Storage, cfgPath, projectName, dbName, version,
+	toolVersion string) (History, error) {
+	project, prjMigration, err := getProjectMigration(cfgPath, projectName, dbName)
+	if err != nil {
+		return History{}, err
+	}
+
+	scheme, err := migration.NewScheme(project.Naming)
+	if err != nil {
+		return History{}, fmt.Errorf("project %s: %w", project.Name, err)
+	}
+
+	files, err := migration.Scan(prjMigration.Paths, scheme)
+	if err != nil {
+		return History{}, err
+	}
+
+	file, ok := migration.Find(files, version)
+	if !ok {
+		return History{}, fmt.Errorf("migration %s not found", version)
+	}
+
+	if err := mStorage.CreateProjectDB(ctx, project.Name, dbName); err != nil {
+		return History{}, fmt.Errorf("create project db: %w", err)
+	}
+
+	if applied, err := mStorage.CheckMigration(ctx, project.Name, dbName, version); err != nil {
+		return History{}, fmt.Errorf("check migration: %w", err)
+	} else if applied {
+		return History{}, fmt.Errorf("migration %s is already applied", version)
+	}
+
+	query, err := file.ReadUp()
+	if err != nil {
+		return History{}, err
+	}
+
+	start := time.Now()
+
+	post := newMigrateRecord(UpOptions{ToolVersion: toolVersion})
+	post.Project = project.Name
+	post.Database = dbName
+	post.Version = version
+	post.ApplyTime = start.UTC().Unix()
+	post.RollFlag = file.Rollback()
+	post.Checksum = migration.Checksum(query)
+
+	if err := mStorage.Up(ctx, &post); err != nil {
+		return History{}, fmt.Errorf("storage up: %w", err)
+	}
+
+	event := newEvent(storage.EventMark, &post)
+	if err := addEvent(ctx, mStorage, event, start); err != nil {
+		return History{}, err
+	}
+
+	log.Println("migration: " + version + " marked as applied")
+
+	return History{Events: []storage.Event{*event}}, nil
+}
+
+// MakeUnmark removes the migration record without reverting it. The recorded event is returned.
+func MakeUnmark(ctx context.Context, mStorage storage.Storage, cfgPath, projectName, dbName, version,
+	toolVersion string) (History, error) {
+	project, _, err := getProjectMigration(cfgPath, projectName, dbName)
+	if err != nil {
+		return History{}, err
+	}
+
+	if applied, err := mStorage.CheckMigration(ctx, project.Name, dbName, version); err != nil {
+		return History{}, fmt.Errorf("check migration: %w", err)
+	} else if !applied {
+		return History{}, fmt.Errorf("migration %s is not applied", version)
+	}
+
+	start := time.Now()
+
+	post := storage.Migrate{Project: project.Name, Database: dbName, Version: version}
+	if err := mStorage.Delete(ctx, &post); err != nil {
+		return History{}, fmt.Errorf("delete: %w", err)
+	}
+
+	event := &storage.Event{
+		Type:        storage.EventUnmark,
+		Project:     project.Name,
+		Database:    dbName,
+		Version:     version,
+		Actor:       newActor(),
+		ToolVersion: toolVersion,
+	}
+
+	if err := addEvent(ctx, mStorage, event, start); err != nil {
+		return History{}, err
+	}
+
+	log.Println("migration: " + version + " unmarked")
+
+	return History{Events: []storage.Event{*event}}, nil
+}
+
+// getProjectMigration returns the project and its relation with the database.
+func getProjectMigration(cfgPath, projectName, dbName string) (config.Project, config.ProjectMigration, error) {
+	cfg, err := config.NewConfig(cfgPath, []string{projectName}, []string{dbName})
+	if err != nil {
+		return config.Project{}, config.ProjectMigration{}, fmt.Errorf("get config: %w", err)
+	}
+
+	project, err := cfg.GetProject(projectName)
+	if err != nil {
+		return project, config.ProjectMigration{}, fmt.Errorf("get project: %w", err)
+	}
+
+	prjMigration, err := project.GetProjectMigration(dbName)
+	if err != nil {
+		return project, prjMigration, fmt.Errorf("get current migration: %w", err)
+	}
+
+	return project, prjMigration, nil
+}
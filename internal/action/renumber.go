@@ -0,0 +1,131 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/migration"
+	"github.com/librun/migrago/internal/storage"
+)
+
+// Renumber is a result of the renumber command.
+type Renumber struct {
+	Renames []migration.Rename `json:"renames"`
+	DryRun  bool               `json:"dry_run"`
+}
+
+// MakeRenumber resolves duplicate and out-of-order sequence numbers of unapplied
+// migrations by renaming their files.
+func MakeRenumber(ctx context.Context, mStorage storage.Storage, cfgPath, projectName, dbName string,
+	dryRun bool) (Renumber, error) {
+	cfg, err := config.NewConfig(cfgPath, []string{projectName}, []string{dbName})
+	if err != nil {
+		return Renumber{}, fmt.Errorf("get config: %w", err)
+	}
+
+	project, err := cfg.GetProject(projectName)
+	if err != nil {
+		return Renumber{}, fmt.Errorf("get project: %w", err)
+	}
+
+	if i := strings.Index(dbName, "/"); i >= 0 {
+		return Renumber{}, fmt.Errorf("files are shared by tenants, renumber the database %s", dbName[:i])
+	}
+
+	db, err := project.GetDB(dbName)
+	if err != nil {
+		return Renumber{}, fmt.Errorf("get project db: %w", err)
+	}
+
+	// Files of a database with tenants are renumbered by versions applied to any tenant.
+	dbNames := []string{dbName}
+	if db.Tenants != nil {
+		if dbNames, err = tenantDBNames(ctx, db); err != nil {
+			return Renumber{}, err
+		}
+	}
+
+	var paths []string
+
+	for _, prjMigration := range project.Migrations {
+		if prjMigration.Database.Name == dbName {
+			paths = prjMigration.Paths
+		}
+	}
+
+	scheme, err := migration.NewScheme(project.Naming)
+	if err != nil {
+		return Renumber{}, fmt.Errorf("project %s: %w", project.Name, err)
+	}
+
+	if !scheme.Sequence() {
+		return Renumber{}, fmt.Errorf("project %s naming has no sequence versions, enable sequence to renumber", project.Name)
+	}
+
+	files, err := migration.ScanAll(paths, scheme)
+	if err != nil {
+		return Renumber{}, err
+	}
+
+	applied := map[string]bool{}
+
+	for _, name := range dbNames {
+		if err := mStorage.CreateProjectDB(ctx, project.Name, name); err != nil {
+			return Renumber{}, fmt.Errorf("create project db: %w", err)
+		}
+
+		migrations, err := mStorage.GetLast(ctx, project.Name, name, false, nil)
+		if err != nil {
+			return Renumber{}, fmt.Errorf("get last migration: %w", err)
+		}
+
+		for _, m := range migrations {
+			applied[m.Version] = true
+		}
+	}
+
+	renames, err := scheme.PlanRenumber(files, applied)
+	if err != nil {
+		return Renumber{}, err
+	}
+
+	result := Renumber{Renames: renames, DryRun: dryRun}
+
+	if len(renames) == 0 {
+		log.Println("Nothing to renumber")
+		return result, nil
+	}
+
+	for _, rename := range renames {
+		log.Println("migration: " + rename.Version + " -> " + rename.NewVersion)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	// Rename from the largest number, so new names never take names of files
+	// that are not renamed yet.
+	for i := len(renames) - 1; i >= 0; i-- {
+		if err := renames[i].Apply(); err != nil {
+			return Renumber{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// Table returns versions and new versions of renamed migrations.
+func (r Renumber) Table() ([]string, [][]string) {
+	header := []string{"version", "new version"}
+	rows := make([][]string, 0, len(r.Renames))
+
+	for _, rename := range r.Renames {
+		rows = append(rows, []string{rename.Version, rename.NewVersion})
+	}
+
+	return header, rows
+}
@@ -0,0 +1,144 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/librun/migrago/internal/database"
+	"github.com/librun/migrago/internal/migration"
+	"github.com/librun/migrago/internal/storage"
+)
+
+// scriptLabels are names of script kinds in logs and errors.
+var scriptLabels = map[string]string{
+	storage.ScriptRepeatable: "repeatable migration",
+	storage.ScriptSeed:       "seed",
+}
+
+// scriptEvents are types of history events of applied scripts by kind.
+var scriptEvents = map[string]string{
+	storage.ScriptRepeatable: storage.EventRepeat,
+	storage.ScriptSeed:       storage.EventSeed,
+}
+
+// repeatableRun is a repeatable migration or a seed to apply.
+type repeatableRun struct {
+	migration.Repeatable
+	kind     string
+	query    string
+	checksum string
+}
+
+// changedRepeatables returns scripts of the kind which are not applied to the
+// database or changed since they were applied.
+func changedRepeatables(ctx context.Context, mStorage storage.Storage, kind, projectName, dbName string,
+	repeatables []migration.Repeatable) ([]repeatableRun, error) {
+	if len(repeatables) == 0 {
+		return nil, nil
+	}
+
+	scripts, err := mStorage.GetScripts(ctx, kind, projectName, dbName)
+	if err != nil {
+		return nil, fmt.Errorf("get %ss: %w", scriptLabels[kind], err)
+	}
+
+	applied := make(map[string]string, len(scripts))
+	for _, script := range scripts {
+		applied[script.Name] = script.Checksum
+	}
+
+	var runs []repeatableRun
+
+	for _, r := range repeatables {
+		query, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		checksum := migration.Checksum(query)
+		if applied[r.Name] == checksum {
+			continue
+		}
+
+		runs = append(runs, repeatableRun{Repeatable: r, kind: kind, query: query, checksum: checksum})
+	}
+
+	return runs, nil
+}
+
+// applyRepeatable applies the repeatable migration or the seed and saves its checksum.
+func applyRepeatable(ctx context.Context, logger dbLog, mStorage storage.Storage, dbc *database.DB, projectName,
+	dbName string, run repeatableRun, timeout time.Duration, base storage.Migrate, report *DBReport) error {
+	start := time.Now()
+	label := scriptLabels[run.kind]
+
+	event := &storage.Event{
+		Type:        scriptEvents[run.kind],
+		Project:     projectName,
+		Database:    dbName,
+		Version:     run.Name,
+		Actor:       base.AppliedBy + "@" + base.Host,
+		ToolVersion: base.ToolVersion,
+		Description: base.Description,
+	}
+
+	if !migration.IsEmpty(run.query) {
+		if errExec := execMigration(ctx, dbc, run.query, timeout); errExec != nil {
+			logger.Println(label + " fail: " + run.Name)
+
+			report.Failed = run.Name
+			report.addResult(run.Name, start, errExec)
+
+			event.Type = storage.EventFailure
+			event.Error = errExec.Error()
+
+			resCtx, cancel := resultContext()
+			if err := addEvent(resCtx, mStorage, event, start); err != nil {
+				logger.Println(err)
+			}
+			cancel()
+
+			return errExec
+		}
+	}
+
+	script := storage.Script{
+		Kind:        run.kind,
+		Project:     projectName,
+		Database:    dbName,
+		Name:        run.Name,
+		ApplyTime:   time.Now().UTC().Unix(),
+		Checksum:    run.checksum,
+		DurationMs:  time.Since(start).Milliseconds(),
+		AppliedBy:   base.AppliedBy,
+		Host:        base.Host,
+		ToolVersion: base.ToolVersion,
+	}
+
+	resCtx, cancel := resultContext()
+	err := mStorage.SaveScript(resCtx, &script)
+
+	if err == nil {
+		err = addEvent(resCtx, mStorage, event, start)
+	}
+
+	cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%s %s is applied, but its record is not saved: %w", label, run.Name, err)
+
+		logger.Println(label + " fail: " + run.Name)
+		report.Failed = run.Name
+		report.addResult(run.Name, start, err)
+
+		return err
+	}
+
+	logger.Println(label + " success: " + run.Name)
+
+	report.Done = append(report.Done, run.Name)
+	report.addResult(run.Name, start, nil)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package action
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuses of project databases in run reports.
+const (
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+	StatusSkipped  = "skipped"
+)
+
+// DBReport is a result of migrations of a project database.
+type DBReport struct {
+	Project  string `json:"project"`
+	Database string `json:"database"`
+	Status   string `json:"status"`
+	// Done contains applied (or reverted) migrations, Pending contains the
+	// migrations which were not, Failed is the migration which failed.
+	Done    []string `json:"done"`
+	Failed  string   `json:"failed"`
+	Pending []string `json:"pending"`
+	Error   string   `json:"error"`
+	// DurationMs is the time of the database migrations in milliseconds.
+	DurationMs int64 `json:"duration_ms"`
+	// Migrations are results of executed migrations: done ones and the failed one.
+	Migrations []MigrationResult `json:"migrations"`
+}
+
+// MigrationResult is a result of an executed migration.
+type MigrationResult struct {
+	Version    string `json:"version"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error"`
+}
+
+// Report is a result of a run over project databases.
+type Report struct {
+	Databases []DBReport `json:"databases"`
+}
+
+// addResult adds the result of the migration executed since start, err is the migration error.
+func (r *DBReport) addResult(version string, start time.Time, err error) {
+	result := MigrationResult{Version: version, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	r.Migrations = append(r.Migrations, result)
+}
+
+// finish sets the status of the database by the error of its migrations.
+func (r *DBReport) finish(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		r.Status = StatusDone
+	case ctx.Err() != nil:
+		r.Status = StatusCanceled
+	default:
+		r.Status = StatusFailed
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Log logs the report, verb describes done migrations: applied or reverted.
+func (r *Report) Log(verb string) {
+	log.Println("Report:")
+
+	for _, db := range r.Databases {
+		if db.Status == StatusSkipped {
+			log.Printf("%s/%s: %s", db.Project, db.Database, db.Status)
+		} else {
+			log.Printf("%s/%s: %s in %s", db.Project, db.Database, db.Status,
+				time.Duration(db.DurationMs)*time.Millisecond)
+		}
+
+		if len(db.Done) > 0 {
+			log.Println("  " + verb + ": " + strings.Join(db.Done, ", "))
+		}
+
+		if db.Failed != "" {
+			log.Println("  failed: " + db.Failed + ": " + db.Error)
+		} else if db.Error != "" {
+			log.Println("  error: " + db.Error)
+		}
+
+		if len(db.Pending) > 0 {
+			log.Println("  not " + verb + ": " + strings.Join(db.Pending, ", "))
+		}
+	}
+}
+
+// Table returns databases of the report with numbers of done and pending migrations.
+func (r Report) Table() ([]string, [][]string) {
+	header := []string{"project", "database", "status", "done", "pending", "failed", "duration", "error"}
+	rows := make([][]string, 0, len(r.Databases))
+
+	for _, db := range r.Databases {
+		rows = append(rows, []string{
+			db.Project,
+			db.Database,
+			db.Status,
+			strconv.Itoa(len(db.Done)),
+			strconv.Itoa(len(db.Pending)),
+			db.Failed,
+			(time.Duration(db.DurationMs) * time.Millisecond).String(),
+			db.Error,
+		})
+	}
+
+	return header, rows
+}
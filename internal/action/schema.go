@@ -0,0 +1,202 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/database"
+	"github.com/librun/migrago/internal/storage"
+)
+
+type (
+	// SchemaDump is a result of the schema dump command.
+	SchemaDump struct {
+		Database   string `json:"database"`
+		File       string `json:"file"`
+		Statements int    `json:"statements"`
+	}
+
+	// SchemaDiff is a result of the schema diff command: statements of the
+	// schema file missing in the database and statements of the database
+	// missing in the schema file.
+	SchemaDiff struct {
+		Database   string   `json:"database"`
+		File       string   `json:"file"`
+		Missing    []string `json:"missing"`
+		Unexpected []string `json:"unexpected"`
+	}
+)
+
+// Table implements the output.Table interface.
+func (d SchemaDump) Table() ([]string, [][]string) {
+	return []string{"database", "file", "statements"},
+		[][]string{{d.Database, d.File, strconv.Itoa(d.Statements)}}
+}
+
+// Table implements the output.Table interface.
+func (d SchemaDiff) Table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(d.Missing)+len(d.Unexpected))
+
+	for _, statement := range d.Missing {
+		rows = append(rows, []string{"missing", statement})
+	}
+
+	for _, statement := range d.Unexpected {
+		rows = append(rows, []string{"unexpected", statement})
+	}
+
+	return []string{"change", "statement"}, rows
+}
+
+// MakeSchemaDump writes the schema of the database to the file, "-" is stdout.
+// The schema file of the database is used if the path is empty.
+func MakeSchemaDump(ctx context.Context, cfgPath, dbName, path string) (SchemaDump, error) {
+	db, path, err := getSchemaDB(cfgPath, dbName, path)
+	if err != nil {
+		return SchemaDump{}, err
+	}
+
+	relations, err := storage.Relations(cfgPath)
+	if err != nil {
+		return SchemaDump{}, err
+	}
+
+	dbc, err := database.NewDB(ctx, &db)
+	if err != nil {
+		return SchemaDump{}, err
+	}
+	defer dbc.Close()
+
+	statements, err := dbc.DumpSchema(ctx, relations)
+	if err != nil {
+		return SchemaDump{}, err
+	}
+
+	if path == "-" {
+		_, err = os.Stdout.WriteString(database.FormatSchema(statements))
+	} else {
+		err = ioutil.WriteFile(path, []byte(database.FormatSchema(statements)), 0644)
+	}
+
+	if err != nil {
+		return SchemaDump{}, fmt.Errorf("write schema: %w", err)
+	}
+
+	if path != "-" {
+		log.Printf("Dumped %d statements of database %s to %s", len(statements), db.Name, path)
+	}
+
+	return SchemaDump{Database: db.Name, File: path, Statements: len(statements)}, nil
+}
+
+// MakeSchemaDiff compares the schema of the database with the schema file and
+// returns an error if they differ, for example after changes made outside of
+// migrations. The schema file of the database is used if the path is empty.
+func MakeSchemaDiff(ctx context.Context, cfgPath, dbName, path string) (SchemaDiff, error) {
+	db, path, err := getSchemaDB(cfgPath, dbName, path)
+	if err != nil {
+		return SchemaDiff{}, err
+	}
+
+	relations, err := storage.Relations(cfgPath)
+	if err != nil {
+		return SchemaDiff{}, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SchemaDiff{}, fmt.Errorf("read schema: %w", err)
+	}
+
+	dbc, err := database.NewDB(ctx, &db)
+	if err != nil {
+		return SchemaDiff{}, err
+	}
+	defer dbc.Close()
+
+	live, err := dbc.DumpSchema(ctx, relations)
+	if err != nil {
+		return SchemaDiff{}, err
+	}
+
+	// Bodies of functions can have empty lines, so the dump is split as the file is.
+	live = database.ParseSchema(database.FormatSchema(live))
+
+	diff := SchemaDiff{Database: db.Name, File: path}
+	diff.Missing, diff.Unexpected = database.DiffSchema(database.ParseSchema(string(content)), live)
+
+	for _, line := range formatSchemaDiff(diff.Missing, diff.Unexpected) {
+		log.Println(line)
+	}
+
+	if changed := len(diff.Missing) + len(diff.Unexpected); changed > 0 {
+		return diff, fmt.Errorf("schema of database %s drifted from %s: %d statements differ", db.Name, path, changed)
+	}
+
+	log.Printf("Schema of database %s matches %s", db.Name, path)
+
+	return diff, nil
+}
+
+// formatSchemaDiff returns missing statements prefixed by "- " and unexpected
+// statements prefixed by "+ ".
+func formatSchemaDiff(missing, unexpected []string) []string {
+	lines := make([]string, 0, len(missing)+len(unexpected))
+
+	for _, statement := range missing {
+		lines = append(lines, "- "+statement)
+	}
+
+	for _, statement := range unexpected {
+		lines = append(lines, "+ "+statement)
+	}
+
+	return lines
+}
+
+// getSchemaDB returns the database and the path of its schema file.
+func getSchemaDB(cfgPath, dbName, path string) (config.Database, string, error) {
+	cfg, err := config.NewConfig(cfgPath, nil, []string{dbName})
+	if err != nil {
+		return config.Database{}, "", fmt.Errorf("config open: %w", err)
+	}
+
+	db, err := cfg.GetDB(dbName)
+	if err != nil {
+		return config.Database{}, "", err
+	}
+
+	if db.Tenants != nil {
+		return config.Database{}, "", fmt.Errorf("database %s has tenants, select one as %s/<tenant>", dbName, dbName)
+	}
+
+	if path == "" {
+		path = db.SchemaFile
+	}
+
+	if path == "" {
+		return config.Database{}, "", errors.New("schema file required: set schema_file of the database or --file")
+	}
+
+	return db, path, nil
+}
+
+// writeSchema dumps the schema of the database without the storage relations to the file.
+func writeSchema(ctx context.Context, dbc *database.DB, path string, relations []string) error {
+	statements, err := dbc.DumpSchema(ctx, relations)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(database.FormatSchema(statements)), 0644); err != nil {
+		return fmt.Errorf("write schema: %w", err)
+	}
+
+	return nil
+}
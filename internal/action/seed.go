@@ -0,0 +1,147 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/database"
+	"github.com/librun/migrago/internal/migration"
+	"github.com/librun/migrago/internal/storage"
+)
+
+// SeedOptions contains options of applying seeds.
+type SeedOptions struct {
+	// Project and Database limit seeds to a project and a database, empty means all.
+	Project  string
+	Database string
+	// Env selects seeds of the environment in addition to seeds of all environments.
+	Env string
+	// Tenant is a glob pattern of tenants to seed, empty means all databases and tenants.
+	Tenant      string
+	ToolVersion string
+	// Description is a free-form description saved in history.
+	Description string
+	// MigrationTimeout limits the execution of each seed, zero means no limit.
+	MigrationTimeout time.Duration
+}
+
+// MakeSeed applies seeds which are not applied to databases or changed since
+// they were applied. Seeds have their own records in the storage, so they are
+// never reverted by down. Seeding stops at the first failed seed, the report
+// is returned with the error.
+func MakeSeed(ctx context.Context, mStorage storage.Storage, cfgPath string, opts SeedOptions) (Report, error) {
+	var projects, databases []string
+
+	if opts.Project != "" {
+		projects = append(projects, opts.Project)
+	}
+
+	if opts.Database != "" {
+		databases = append(databases, opts.Database)
+	}
+
+	cfg, err := config.NewConfig(cfgPath, projects, databases)
+	if err != nil {
+		return Report{}, fmt.Errorf("get config: %w", err)
+	}
+
+	if err := expandTenants(ctx, &cfg, opts.Tenant); err != nil {
+		return Report{}, err
+	}
+
+	base := newMigrateRecord(UpOptions{ToolVersion: opts.ToolVersion, Description: opts.Description})
+
+	var report Report
+
+	for _, project := range cfg.Projects {
+		for _, prjMigration := range project.Migrations {
+			if len(prjMigration.Seeds) == 0 {
+				continue
+			}
+
+			if len(report.Databases) == 0 || report.Databases[len(report.Databases)-1].Project != project.Name {
+				log.Println("Project: " + project.Name)
+				log.Println("----------")
+			}
+
+			dbName := prjMigration.Database.Name
+			log.Println("DB: " + dbName)
+
+			report.Databases = append(report.Databases, DBReport{Project: project.Name, Database: dbName})
+			dbReport := &report.Databases[len(report.Databases)-1]
+
+			start := time.Now()
+			err := seedDatabase(ctx, mStorage, prjMigration, project.Name, opts, base, dbReport)
+			dbReport.DurationMs = time.Since(start).Milliseconds()
+			dbReport.finish(ctx, err)
+
+			if err != nil {
+				report.Log("seeded")
+				return report, err
+			}
+		}
+	}
+
+	if len(report.Databases) == 0 {
+		return report, errors.New("no seeds in config, add seeds directories to projects")
+	}
+
+	return report, nil
+}
+
+// seedDatabase applies seeds of the project database.
+func seedDatabase(ctx context.Context, mStorage storage.Storage, prjMigration config.ProjectMigration,
+	projectName string, opts SeedOptions, base storage.Migrate, report *DBReport) error {
+	defer log.Println("----------")
+
+	seeds, err := migration.ScanSeeds(prjMigration.Seeds, opts.Env)
+	if err != nil {
+		return err
+	}
+
+	if err := mStorage.CreateProjectDB(ctx, projectName, prjMigration.Database.Name); err != nil {
+		return fmt.Errorf("create project db: %w", err)
+	}
+
+	runs, err := changedRepeatables(ctx, mStorage, storage.ScriptSeed, projectName, prjMigration.Database.Name, seeds)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		log.Println("Completed seeds:", len(report.Done), "of", len(runs))
+	}()
+
+	if len(runs) == 0 {
+		return nil
+	}
+
+	for _, run := range runs {
+		report.Pending = append(report.Pending, run.Name)
+	}
+
+	dbc, err := database.NewDB(ctx, prjMigration.Database)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	for _, run := range runs {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %w", err)
+		}
+
+		report.Pending = report.Pending[1:]
+
+		if err := applyRepeatable(ctx, dbLog(""), mStorage, dbc, projectName, prjMigration.Database.Name, run,
+			opts.MigrationTimeout, base, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
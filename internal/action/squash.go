@@ -0,0 +1,362 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/database"
+	"github.com/librun/migrago/internal/migration"
+	"github.com/librun/migrago/internal/storage"
+)
+
+// Squash is a result of the squash command.
+type Squash struct {
+	Version  string   `json:"version"`
+	Squashed []string `json:"squashed"`
+	// Removed are deleted files of the squashed migrations.
+	Removed []string `json:"removed"`
+	// Marked are databases whose records of the squashed migrations are
+	// replaced by the record of the baseline.
+	Marked []string `json:"marked"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// SquashOptions contains options of squashing migrations.
+type SquashOptions struct {
+	// Version is the newest squashed migration, it becomes the baseline.
+	Version string
+	// FromSchema makes the baseline from the schema dump of the database
+	// instead of the concatenation of the squashed migrations.
+	FromSchema  bool
+	DryRun      bool
+	ToolVersion string
+}
+
+// squashState is a state of a database relative to squashed migrations.
+type squashState int
+
+const (
+	// squashNone means no squashed migration is applied, the baseline is applied instead.
+	squashNone squashState = iota
+	// squashAll means all squashed migrations are applied, their records are replaced by the baseline.
+	squashAll
+	// squashDone means only the baseline is applied, the database is squashed already.
+	squashDone
+)
+
+// MakeSquash squashes migrations of the database up to the version into a
+// baseline migration which takes the version and the files of the newest
+// squashed migration. Files of the other squashed migrations are deleted.
+// Databases of the storage which applied the squashed migrations get the
+// record of the baseline instead of their records, new databases apply the
+// baseline only. Running the command again for a baseline updates records
+// of the storage only, so storages of other environments are updated too.
+func MakeSquash(ctx context.Context, mStorage storage.Storage, cfgPath, projectName, dbName string,
+	opts SquashOptions) (Squash, error) {
+	cfg, err := config.NewConfig(cfgPath, []string{projectName}, []string{dbName})
+	if err != nil {
+		return Squash{}, fmt.Errorf("get config: %w", err)
+	}
+
+	project, err := cfg.GetProject(projectName)
+	if err != nil {
+		return Squash{}, fmt.Errorf("get project: %w", err)
+	}
+
+	if i := strings.Index(dbName, "/"); i >= 0 {
+		return Squash{}, fmt.Errorf("files are shared by tenants, squash the database %s", dbName[:i])
+	}
+
+	db, err := project.GetDB(dbName)
+	if err != nil {
+		return Squash{}, fmt.Errorf("get project db: %w", err)
+	}
+
+	var paths []string
+
+	for _, prjMigration := range project.Migrations {
+		if prjMigration.Database.Name == dbName {
+			paths = prjMigration.Paths
+		}
+	}
+
+	scheme, err := migration.NewScheme(project.Naming)
+	if err != nil {
+		return Squash{}, fmt.Errorf("project %s: %w", project.Name, err)
+	}
+
+	files, err := migration.Scan(paths, scheme)
+	if err != nil {
+		return Squash{}, err
+	}
+
+	baseline, ok := migration.Find(files, opts.Version)
+	if !ok {
+		return Squash{}, fmt.Errorf("migration %s not found", opts.Version)
+	}
+
+	// A baseline which is the oldest migration is squashed already.
+	squashed, err := baseline.Squashed()
+	if err != nil {
+		return Squash{}, err
+	}
+
+	squashedFiles := len(squashed) > 0 && files[0].Version == baseline.Version
+
+	dbNames := []string{dbName}
+	if db.Tenants != nil {
+		if dbNames, err = tenantDBNames(ctx, db); err != nil {
+			return Squash{}, err
+		}
+	}
+
+	var plan migration.Squash
+
+	if !squashedFiles {
+		schema := ""
+
+		if opts.FromSchema {
+			if schema, err = dumpBaseline(ctx, mStorage, cfgPath, project.Name, db, files, baseline.Version); err != nil {
+				return Squash{}, err
+			}
+		}
+
+		if plan, err = migration.PlanSquash(files, baseline.Version, schema); err != nil {
+			return Squash{}, err
+		}
+
+		squashed = plan.Squashed
+	}
+
+	// Databases which applied a part of the squashed migrations can not use the baseline.
+	states := make(map[string]squashState, len(dbNames))
+
+	for _, name := range dbNames {
+		if err := mStorage.CreateProjectDB(ctx, project.Name, name); err != nil {
+			return Squash{}, fmt.Errorf("create project db: %w", err)
+		}
+
+		states[name], err = getSquashState(ctx, mStorage, scheme, project.Name, name, baseline.Version, squashed)
+		if err != nil {
+			return Squash{}, err
+		}
+	}
+
+	result := Squash{Version: baseline.Version, Squashed: squashed, Removed: plan.Removed, DryRun: opts.DryRun}
+
+	for _, name := range dbNames {
+		if states[name] == squashAll {
+			result.Marked = append(result.Marked, name)
+		}
+	}
+
+	for _, version := range squashed {
+		log.Println("migration squashed: " + version)
+	}
+
+	for _, path := range plan.Removed {
+		log.Println("file removed: " + path)
+	}
+
+	log.Println("baseline: " + baseline.Version)
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if !squashedFiles {
+		if err := plan.Apply(); err != nil {
+			return result, err
+		}
+
+		// The down file of the baseline may be removed.
+		if files, err = migration.Scan(paths, scheme); err != nil {
+			return result, err
+		}
+
+		baseline, _ = migration.Find(files, baseline.Version)
+	}
+
+	// The checksum is taken from the written file, as up does.
+	query, err := baseline.ReadUp()
+	if err != nil {
+		return result, err
+	}
+
+	for _, name := range result.Marked {
+		if err := markSquash(ctx, mStorage, scheme, project.Name, name, baseline, migration.Checksum(query),
+			opts.ToolVersion); err != nil {
+			return result, err
+		}
+
+		log.Println("database marked: " + name)
+	}
+
+	return result, nil
+}
+
+// Table returns the baseline, squashed migrations and marked databases.
+func (s Squash) Table() ([]string, [][]string) {
+	header := []string{"baseline", "squashed", "marked databases"}
+	rows := [][]string{{s.Version, strings.Join(s.Squashed, ", "), strings.Join(s.Marked, ", ")}}
+
+	return header, rows
+}
+
+// getSquashState returns the state of the database relative to the squashed
+// migrations, an error if a part of them is applied. Records older than the
+// baseline are squashed migrations, including migrations of earlier baselines.
+func getSquashState(ctx context.Context, mStorage storage.Storage, scheme *migration.Scheme, projectName,
+	dbName, version string, squashed []string) (squashState, error) {
+	migrations, err := mStorage.GetLast(ctx, projectName, dbName, false, nil)
+	if err != nil {
+		return squashNone, fmt.Errorf("get last migration: %w", err)
+	}
+
+	applied := map[string]bool{}
+	older := 0
+
+	for _, m := range migrations {
+		applied[m.Version] = true
+
+		if scheme.Less(m.Version, version) {
+			older++
+		}
+	}
+
+	var missing []string
+
+	for _, v := range squashed {
+		if !applied[v] {
+			missing = append(missing, v)
+		}
+	}
+
+	switch {
+	case !applied[version] && older == 0:
+		return squashNone, nil
+	case applied[version] && older == 0:
+		return squashDone, nil
+	case len(missing) == 0:
+		return squashAll, nil
+	}
+
+	return squashNone, fmt.Errorf("database %s applied a part of migrations squashed into %s, apply them first: %s",
+		dbName, version, strings.Join(missing, ", "))
+}
+
+// markSquash replaces records of the squashed migrations by the record of the
+// baseline. The record of the baseline is replaced first, so an interrupted
+// run leaves all squashed records and is completed by running squash again.
+func markSquash(ctx context.Context, mStorage storage.Storage, scheme *migration.Scheme, projectName, dbName string,
+	baseline migration.File, checksum string, toolVersion string) error {
+	start := time.Now()
+
+	migrations, err := mStorage.GetLast(ctx, projectName, dbName, false, nil)
+	if err != nil {
+		return fmt.Errorf("get last migration: %w", err)
+	}
+
+	var (
+		post     *storage.Migrate
+		squashed []storage.Migrate
+	)
+
+	for i := range migrations {
+		switch {
+		case migrations[i].Version == baseline.Version:
+			post = &migrations[i]
+		case scheme.Less(migrations[i].Version, baseline.Version):
+			squashed = append(squashed, migrations[i])
+		}
+	}
+
+	if post == nil {
+		return fmt.Errorf("migration %s of database %s not found", baseline.Version, dbName)
+	}
+
+	post.Checksum = checksum
+	post.RollFlag = baseline.Rollback()
+
+	if err := mStorage.Replace(ctx, post); err != nil {
+		return fmt.Errorf("storage replace: %w", err)
+	}
+
+	versions := make([]string, 0, len(squashed))
+
+	// Records are listed newest first.
+	for i := len(squashed) - 1; i >= 0; i-- {
+		if err := mStorage.Delete(ctx, &squashed[i]); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+
+		versions = append(versions, squashed[i].Version)
+	}
+
+	event := &storage.Event{
+		Type:        storage.EventSquash,
+		Project:     projectName,
+		Database:    dbName,
+		Version:     baseline.Version,
+		Actor:       newActor(),
+		ToolVersion: toolVersion,
+		Description: "squashed: " + strings.Join(versions, ", "),
+	}
+
+	return addEvent(ctx, mStorage, event, start)
+}
+
+// dumpBaseline returns the schema of the database without tables of the storage
+// as the baseline. The database must have applied exactly the migrations up to the version.
+func dumpBaseline(ctx context.Context, mStorage storage.Storage, cfgPath, projectName string, db config.Database,
+	files []migration.File, version string) (string, error) {
+	if db.Tenants != nil {
+		return "", errors.New("schema of a database with tenants can not be dumped, squash by concatenation")
+	}
+
+	squashed := true
+
+	for _, file := range files {
+		applied, err := mStorage.CheckMigration(ctx, projectName, db.Name, file.Version)
+		if err != nil {
+			return "", fmt.Errorf("check migration: %w", err)
+		}
+
+		if applied != squashed {
+			if squashed {
+				return "", fmt.Errorf("database %s did not apply migration %s, its schema is not the baseline",
+					db.Name, file.Version)
+			}
+
+			return "", fmt.Errorf("database %s applied migration %s, its schema is not the baseline",
+				db.Name, file.Version)
+		}
+
+		if file.Version == version {
+			squashed = false
+		}
+	}
+
+	relations, err := storage.Relations(cfgPath)
+	if err != nil {
+		return "", err
+	}
+
+	dbc, err := database.NewDB(ctx, &db)
+	if err != nil {
+		return "", err
+	}
+	defer dbc.Close()
+
+	statements, err := dbc.DumpSchema(ctx, relations)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(statements, "\n\n"), nil
+}
@@ -0,0 +1,119 @@
+package action
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+	"time"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/migration"
+)
+
+// TemplateData contains variables available in migration templates.
+type TemplateData struct {
+	Name         string
+	Version      string
+	Project      string
+	Database     string
+	DatabaseType string
+	Author       string
+	Date         time.Time
+}
+
+// templateHeader is a common header of default templates.
+const templateHeader = `-- Migration: {{.Name}}
+-- Version: {{.Version}}
+-- Project: {{.Project}}, database: {{.Database}}
+-- Author: {{.Author}}, created: {{.Date.Format "2006-01-02 15:04:05"}}
+`
+
+// defaultTemplates are used when no template is configured for a database type.
+// Each migration file is executed by migrago in a transaction.
+var defaultTemplates = map[string]config.Templates{
+	"postgres": {
+		Up:   templateHeader + "\nSET LOCAL lock_timeout = '5s';\n\n",
+		Down: templateHeader + "\nSET LOCAL lock_timeout = '5s';\n\n",
+		Single: templateHeader + "\n" + migration.MarkerUp + "\nSET LOCAL lock_timeout = '5s';\n\n" +
+			migration.MarkerDown + "\nSET LOCAL lock_timeout = '5s';\n\n",
+	},
+	"mysql": {
+		// DDL statements cause an implicit commit in MySQL.
+		Up:   templateHeader + "-- Note: DDL statements are not transactional in MySQL.\n\n",
+		Down: templateHeader + "-- Note: DDL statements are not transactional in MySQL.\n\n",
+		Single: templateHeader + "-- Note: DDL statements are not transactional in MySQL.\n\n" +
+			migration.MarkerUp + "\n\n" + migration.MarkerDown + "\n\n",
+	},
+	"clickhouse": {
+		Up:     templateHeader + "-- Note: ClickHouse executes one statement per migration file.\n\n",
+		Down:   templateHeader + "-- Note: ClickHouse executes one statement per migration file.\n\n",
+		Single: templateHeader + "\n" + migration.MarkerUp + "\n\n" + migration.MarkerDown + "\n\n",
+	},
+}
+
+// fallbackTemplates are used for databases without default templates.
+var fallbackTemplates = config.Templates{
+	Single: migration.MarkerUp + "\n\n" + migration.MarkerDown + "\n",
+}
+
+// migrationTemplates chooses templates of a project database: the project
+// templates, then templates of the database type, then the defaults.
+type migrationTemplates struct {
+	project config.Templates
+	dbType  config.Templates
+	builtin config.Templates
+}
+
+func newMigrationTemplates(cfg *config.YAMLConfig, project, dbType string) migrationTemplates {
+	builtin, ok := defaultTemplates[dbType]
+	if !ok {
+		builtin = fallbackTemplates
+	}
+
+	return migrationTemplates{
+		project: cfg.Projects[project].Templates,
+		dbType:  cfg.Templates[dbType],
+		builtin: builtin,
+	}
+}
+
+// render renders the template of a new migration file for mode (up, down or single).
+func (t migrationTemplates) render(mode string, data TemplateData) (string, error) {
+	pick := func(tpl config.Templates) string {
+		switch mode {
+		case CreateModeDown:
+			return tpl.Down
+		case CreateModeSingle:
+			return tpl.Single
+		default:
+			return tpl.Up
+		}
+	}
+
+	text := pick(t.builtin)
+
+	// Configured templates are paths to template files.
+	for _, tpl := range []config.Templates{t.dbType, t.project} {
+		if path := pick(tpl); path != "" {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("read template: %w", err)
+			}
+
+			text = string(content)
+		}
+	}
+
+	tmpl, err := template.New(mode).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+
+	return buf.String(), nil
+}
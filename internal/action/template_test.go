@@ -0,0 +1,80 @@
+package action
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/migration"
+)
+
+// TestDefaultTemplatesEmpty checks that untouched files of the default
+// templates are skipped by up and down: drivers fail on comment-only queries.
+func TestDefaultTemplatesEmpty(t *testing.T) {
+	for _, dbType := range []string{"mysql", "clickhouse"} {
+		for _, mode := range []string{CreateModeBoth, CreateModeSingle} {
+			testTemplateEmpty(t, dbType, mode)
+		}
+	}
+}
+
+func testTemplateEmpty(t *testing.T, dbType, mode string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "migrago-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgPath := filepath.Join(dir, "config.yaml")
+	cfg := fmt.Sprintf("projects:\n  p:\n    migrations:\n    - db: %s\ndatabases:\n  db:\n    type: %s\n",
+		filepath.Join(dir, "migrations"), dbType)
+
+	if err := ioutil.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MakeCreate(cfgPath, "init", mode, "p", "db", "author"); err != nil {
+		t.Fatalf("%s %s: %v", dbType, mode, err)
+	}
+
+	scheme, err := migration.NewScheme(config.Naming{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := migration.Scan([]string{filepath.Join(dir, "migrations") + "/"}, scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("%s %s: %d migrations created", dbType, mode, len(files))
+	}
+
+	up, err := files[0].ReadUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !migration.IsEmpty(up) {
+		t.Errorf("%s %s: up is executed: %q", dbType, mode, up)
+	}
+
+	if !files[0].Rollback() {
+		return
+	}
+
+	down, err := files[0].ReadDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !migration.IsEmpty(down) {
+		t.Errorf("%s %s: down is executed: %q", dbType, mode, down)
+	}
+}
@@ -0,0 +1,98 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/database"
+)
+
+// expandTenants replaces databases with tenants in project migrations by the
+// databases of tenants matching the glob pattern, an empty pattern matches all
+// tenants. With a pattern databases without tenants are skipped.
+func expandTenants(ctx context.Context, cfg *config.Config, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("tenant pattern %s: %w", pattern, err)
+	}
+
+	// Tenants of a database are listed once for all projects.
+	listed := map[string][]string{}
+	matched := 0
+
+	for i := range cfg.Projects {
+		project := &cfg.Projects[i]
+		migrations := make([]config.ProjectMigration, 0, len(project.Migrations))
+
+		for _, prjMigration := range project.Migrations {
+			db := prjMigration.Database
+
+			if db.Tenants == nil {
+				if pattern == "" {
+					migrations = append(migrations, prjMigration)
+				}
+
+				continue
+			}
+
+			tenants, ok := listed[db.Name]
+			if !ok {
+				var err error
+
+				if tenants, err = database.ListTenants(ctx, db.Tenants); err != nil {
+					return fmt.Errorf("database %s: %w", db.Name, err)
+				}
+
+				listed[db.Name] = tenants
+			}
+
+			for _, tenant := range tenants {
+				if ok, _ := path.Match(pattern, tenant); pattern != "" && !ok {
+					continue
+				}
+
+				tenantDB, err := db.ForTenant(tenant)
+				if err != nil {
+					return err
+				}
+
+				matched++
+
+				tenantMigration := prjMigration
+				tenantMigration.Database = &tenantDB
+
+				migrations = append(migrations, tenantMigration)
+			}
+		}
+
+		project.Migrations = migrations
+	}
+
+	if pattern != "" && matched == 0 {
+		return fmt.Errorf("no tenants match %s", pattern)
+	}
+
+	return nil
+}
+
+// tenantDBNames returns names of databases of all tenants of the database.
+func tenantDBNames(ctx context.Context, db config.Database) ([]string, error) {
+	tenants, err := database.ListTenants(ctx, db.Tenants)
+	if err != nil {
+		return nil, fmt.Errorf("database %s: %w", db.Name, err)
+	}
+
+	names := make([]string, 0, len(tenants))
+
+	for _, tenant := range tenants {
+		tenantDB, err := db.ForTenant(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, tenantDB.Name)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,240 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/librun/migrago/internal/config"
+	"github.com/librun/migrago/internal/database"
+	"github.com/librun/migrago/internal/migration"
+	"github.com/librun/migrago/internal/storage"
+)
+
+// TestOptions contains options of round-trip testing of migrations.
+type TestOptions struct {
+	Project  string
+	Database string
+	// DSN selects the scratch database the migrations are tested against,
+	// Schema replaces the schema of the database if it is not empty.
+	DSN    string
+	Schema string
+	// All tests all migrations of the database. Otherwise migrations which are
+	// applied according to the storage are applied to the empty scratch database
+	// first, and migrations which are not applied are tested.
+	All bool
+	// CompareSchema checks that the schema after the down migration equals the
+	// schema before the up migration.
+	CompareSchema bool
+	// MigrationTimeout limits the execution of each migration, zero means no limit.
+	MigrationTimeout time.Duration
+
+	// storageRelations are tables of the storage, they are left out of schemas.
+	storageRelations []string
+}
+
+// MakeTest applies every migration to the scratch database, reverts it by its
+// down migration and applies it again. Testing stops at the first failed
+// migration. The storage is not changed. The report is returned with the error.
+func MakeTest(ctx context.Context, mStorage storage.Storage, cfgPath string, opts TestOptions) (Report, error) {
+	if opts.DSN == "" {
+		return Report{}, errors.New("dsn of a scratch database required")
+	}
+
+	project, prjMigration, err := getProjectMigration(cfgPath, opts.Project, opts.Database)
+	if err != nil {
+		return Report{}, err
+	}
+
+	scheme, err := migration.NewScheme(project.Naming)
+	if err != nil {
+		return Report{}, fmt.Errorf("project %s: %w", project.Name, err)
+	}
+
+	files, err := migration.Scan(prjMigration.Paths, scheme)
+	if err != nil {
+		return Report{}, err
+	}
+
+	dbReport := DBReport{Project: project.Name, Database: opts.Database}
+
+	var prepareFiles, testFiles []migration.File
+
+	for _, file := range files {
+		if !opts.All {
+			applied, err := mStorage.CheckMigration(ctx, project.Name, opts.Database, file.Version)
+			if err != nil {
+				return Report{}, fmt.Errorf("check migration: %w", err)
+			}
+
+			if applied {
+				prepareFiles = append(prepareFiles, file)
+				continue
+			}
+		}
+
+		testFiles = append(testFiles, file)
+		dbReport.Pending = append(dbReport.Pending, file.Version)
+	}
+
+	if opts.CompareSchema {
+		if opts.storageRelations, err = storage.Relations(cfgPath); err != nil {
+			return Report{}, err
+		}
+	}
+
+	scratch := *prjMigration.Database
+	scratch.DSN = opts.DSN
+
+	if opts.Schema != "" {
+		scratch.Schema = opts.Schema
+	}
+
+	start := time.Now()
+	err = testMigrations(ctx, &scratch, prepareFiles, testFiles, opts, &dbReport)
+	dbReport.DurationMs = time.Since(start).Milliseconds()
+	dbReport.finish(ctx, err)
+
+	report := Report{Databases: []DBReport{dbReport}}
+	report.Log("tested")
+
+	return report, err
+}
+
+// testMigrations applies the prepare migrations to the scratch database to
+// bring it to the state of the database, then tests the migrations.
+func testMigrations(ctx context.Context, scratch *config.Database, prepare, files []migration.File,
+	opts TestOptions, report *DBReport) error {
+	dbc, err := database.NewDB(ctx, scratch)
+	if err != nil {
+		return fmt.Errorf("connect to scratch db: %w", err)
+	}
+	defer dbc.Close()
+
+	for _, file := range prepare {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %w", err)
+		}
+
+		up, err := file.ReadUp()
+		if err != nil {
+			return err
+		}
+
+		if err := testExec(ctx, dbc, up, opts); err != nil {
+			return fmt.Errorf("prepare migration %s: %w", file.Version, err)
+		}
+
+		log.Println("migration prepared: " + file.Version)
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %w", err)
+		}
+
+		report.Pending = report.Pending[1:]
+		start := time.Now()
+
+		if err := testMigration(ctx, dbc, file, opts); err != nil {
+			err = fmt.Errorf("migration %s: %w", file.Version, err)
+
+			log.Println("migration fail: " + file.Version)
+			report.Failed = file.Version
+			report.addResult(file.Version, start, err)
+
+			return err
+		}
+
+		if file.Rollback() {
+			log.Println("migration round trip passed: " + file.Version)
+		} else {
+			log.Println("migration applied, no down file: " + file.Version)
+		}
+
+		report.Done = append(report.Done, file.Version)
+		report.addResult(file.Version, start, nil)
+	}
+
+	return nil
+}
+
+// testMigration applies, reverts and applies again the migration. Migrations
+// without down files are applied once, so the next migrations can be tested.
+func testMigration(ctx context.Context, dbc *database.DB, file migration.File, opts TestOptions) error {
+	up, err := file.ReadUp()
+	if err != nil {
+		return err
+	}
+
+	snapshot := func() ([]string, error) {
+		if !opts.CompareSchema {
+			return nil, nil
+		}
+
+		return dbc.DumpSchema(ctx, opts.storageRelations)
+	}
+
+	before, err := snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err := testExec(ctx, dbc, up, opts); err != nil {
+		return fmt.Errorf("up: %w", err)
+	}
+
+	if !file.Rollback() {
+		return nil
+	}
+
+	down, err := file.ReadDown()
+	if err != nil {
+		return err
+	}
+
+	after, err := snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err := testExec(ctx, dbc, down, opts); err != nil {
+		return fmt.Errorf("down: %w", err)
+	}
+
+	reverted, err := snapshot()
+	if err != nil {
+		return err
+	}
+
+	if diff := formatSchemaDiff(database.DiffSchema(before, reverted)); len(diff) > 0 {
+		return fmt.Errorf("schema after down differs from schema before up:\n%s", strings.Join(diff, "\n"))
+	}
+
+	if err := testExec(ctx, dbc, up, opts); err != nil {
+		return fmt.Errorf("up after down: %w", err)
+	}
+
+	reapplied, err := snapshot()
+	if err != nil {
+		return err
+	}
+
+	if diff := formatSchemaDiff(database.DiffSchema(after, reapplied)); len(diff) > 0 {
+		return fmt.Errorf("schema after up after down differs from schema after up:\n%s", strings.Join(diff, "\n"))
+	}
+
+	return nil
+}
+
+// testExec executes the query of a migration, empty queries are skipped.
+func testExec(ctx context.Context, dbc *database.DB, query string, opts TestOptions) error {
+	if migration.IsEmpty(query) {
+		return nil
+	}
+
+	return execMigration(ctx, dbc, query, opts.MigrationTimeout)
+}
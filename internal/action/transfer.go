@@ -0,0 +1,302 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/librun/migrago/internal/storage"
+)
+
+// historyDumpFormat is a version of the history dump format.
+const historyDumpFormat = 1
+
+// historyDump is the history of a storage: migration records, the history log
+// and records of repeatable migrations and seeds.
+type historyDump struct {
+	Format     int               `json:"format"`
+	Migrations []storage.Migrate `json:"migrations"`
+	Events     []storage.Event   `json:"events"`
+	Scripts    []storage.Script  `json:"scripts"`
+}
+
+// TransferReport counts the results of a history transfer, an export or an import.
+type TransferReport struct {
+	Written   int  `json:"written"`
+	Present   int  `json:"present"`
+	Conflicts int  `json:"conflicts"`
+	Events    int  `json:"events"`
+	Scripts   int  `json:"scripts"`
+	DryRun    bool `json:"dry_run"`
+}
+
+// MakeTransfer copies migration records, the history log and records of
+// repeatable migrations and seeds of all projects and databases from the source
+// storage to the destination one. Migration records which already exist in the
+// destination with other values are reported as conflicts and are not copied.
+// Records of repeatable migrations and seeds are copied unless the destination
+// has a record applied at the same time or later.
+func MakeTransfer(ctx context.Context, src, dst storage.Storage, dryRun bool) (TransferReport, error) {
+	dump, err := readHistory(ctx, src)
+	if err != nil {
+		return TransferReport{}, err
+	}
+
+	return writeHistory(ctx, dst, dump, dryRun)
+}
+
+// MakeExport writes migration records, the history log and records of
+// repeatable migrations and seeds of the storage to the file in JSON, "-" is stdout.
+func MakeExport(ctx context.Context, mStorage storage.Storage, path string) (TransferReport, error) {
+	dump, err := readHistory(ctx, mStorage)
+	if err != nil {
+		return TransferReport{}, err
+	}
+
+	content, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return TransferReport{}, fmt.Errorf("encode history: %w", err)
+	}
+
+	if path == "-" {
+		_, err = os.Stdout.Write(append(content, '\n'))
+	} else {
+		err = ioutil.WriteFile(path, content, 0644)
+	}
+
+	if err != nil {
+		return TransferReport{}, fmt.Errorf("write history: %w", err)
+	}
+
+	log.Printf("Exported %d migrations, %d events and %d scripts", len(dump.Migrations), len(dump.Events),
+		len(dump.Scripts))
+
+	return TransferReport{Written: len(dump.Migrations), Events: len(dump.Events), Scripts: len(dump.Scripts)}, nil
+}
+
+// MakeImport loads the history exported by MakeExport from the file, "-" is stdin, into the storage.
+func MakeImport(ctx context.Context, mStorage storage.Storage, path string, dryRun bool) (TransferReport, error) {
+	var (
+		content []byte
+		err     error
+	)
+
+	if path == "-" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(path)
+	}
+
+	if err != nil {
+		return TransferReport{}, fmt.Errorf("read history: %w", err)
+	}
+
+	dump := historyDump{}
+	if err := json.Unmarshal(content, &dump); err != nil {
+		return TransferReport{}, fmt.Errorf("decode history: %w", err)
+	}
+
+	if dump.Format != historyDumpFormat {
+		return TransferReport{}, fmt.Errorf("unsupported history format %d", dump.Format)
+	}
+
+	return writeHistory(ctx, mStorage, dump, dryRun)
+}
+
+func readHistory(ctx context.Context, mStorage storage.Storage) (historyDump, error) {
+	migrations, err := mStorage.GetAll(ctx)
+	if err != nil {
+		return historyDump{}, fmt.Errorf("get migrations: %w", err)
+	}
+
+	events, err := mStorage.GetEvents(ctx, storage.EventFilter{})
+	if err != nil {
+		return historyDump{}, fmt.Errorf("get events: %w", err)
+	}
+
+	scripts, err := mStorage.GetAllScripts(ctx)
+	if err != nil {
+		return historyDump{}, fmt.Errorf("get scripts: %w", err)
+	}
+
+	return historyDump{Format: historyDumpFormat, Migrations: migrations, Events: events, Scripts: scripts}, nil
+}
+
+// writeHistory adds the history to the storage. Records and events which are
+// already in the storage are skipped, so the history can be written again.
+func writeHistory(ctx context.Context, mStorage storage.Storage, dump historyDump,
+	dryRun bool) (TransferReport, error) {
+	existing, err := mStorage.GetAll(ctx)
+	if err != nil {
+		return TransferReport{}, fmt.Errorf("get migrations: %w", err)
+	}
+
+	type key struct{ project, database, version string }
+
+	records := make(map[key]storage.Migrate, len(existing))
+	for _, m := range existing {
+		records[key{m.Project, m.Database, m.Version}] = m
+	}
+
+	events, err := mStorage.GetEvents(ctx, storage.EventFilter{})
+	if err != nil {
+		return TransferReport{}, fmt.Errorf("get events: %w", err)
+	}
+
+	// Events have no ids, they are identified by the time, the type and the migration.
+	type eventKey struct {
+		time                             int64
+		kind, project, database, version string
+	}
+
+	present := make(map[eventKey]bool, len(events))
+
+	var newest int64
+
+	for _, e := range events {
+		present[eventKey{e.Time, e.Type, e.Project, e.Database, e.Version}] = true
+
+		if e.Time > newest {
+			newest = e.Time
+		}
+	}
+
+	var newEvents []storage.Event
+
+	for _, e := range dump.Events {
+		if !present[eventKey{e.Time, e.Type, e.Project, e.Database, e.Version}] {
+			newEvents = append(newEvents, e)
+		}
+	}
+
+	sort.SliceStable(newEvents, func(i, j int) bool { return newEvents[i].Time < newEvents[j].Time })
+
+	// Events are appended to the log, older events after newer ones would break its order.
+	if len(newEvents) > 0 && newEvents[0].Time < newest {
+		return TransferReport{}, fmt.Errorf("the history log of the storage has events newer than the events to write, " +
+			"write the history to a storage without newer events")
+	}
+
+	report := TransferReport{DryRun: dryRun}
+
+	for i := range dump.Migrations {
+		m := dump.Migrations[i]
+
+		// A failed attempt in the storage is replaced by any record.
+		if old, ok := records[key{m.Project, m.Database, m.Version}]; ok {
+			if old == m {
+				report.Present++
+				continue
+			}
+
+			if !old.Failed {
+				report.Conflicts++
+				log.Printf("conflict: %s/%s %s: applied at %d in the storage, at %d in the source",
+					m.Project, m.Database, m.Version, old.ApplyTime, m.ApplyTime)
+
+				continue
+			}
+		}
+
+		report.Written++
+
+		if dryRun {
+			log.Printf("migration: %s/%s %s", m.Project, m.Database, m.Version)
+			continue
+		}
+
+		if err := mStorage.CreateProjectDB(ctx, m.Project, m.Database); err != nil {
+			return report, fmt.Errorf("create project db: %w", err)
+		}
+
+		if err := mStorage.Up(ctx, &m); err != nil {
+			return report, fmt.Errorf("save migration %s/%s %s: %w", m.Project, m.Database, m.Version, err)
+		}
+	}
+
+	for i := range newEvents {
+		report.Events++
+
+		if dryRun {
+			continue
+		}
+
+		if err := mStorage.AddEvent(ctx, &newEvents[i]); err != nil {
+			return report, fmt.Errorf("add event: %w", err)
+		}
+	}
+
+	if err := writeScripts(ctx, mStorage, dump.Scripts, &report); err != nil {
+		return report, err
+	}
+
+	verb := "Written"
+	if dryRun {
+		verb = "To write"
+	}
+
+	log.Printf("%s: %d migrations, %d events, %d scripts; already present: %d migrations; conflicts: %d",
+		verb, report.Written, report.Events, report.Scripts, report.Present, report.Conflicts)
+
+	if report.Conflicts > 0 {
+		return report, fmt.Errorf("%d conflicting migrations are not written", report.Conflicts)
+	}
+
+	return report, nil
+}
+
+// writeScripts saves records of repeatable migrations and seeds to the storage.
+// A record is kept if the storage has the script applied at the same time or later.
+func writeScripts(ctx context.Context, mStorage storage.Storage, scripts []storage.Script,
+	report *TransferReport) error {
+	existing, err := mStorage.GetAllScripts(ctx)
+	if err != nil {
+		return fmt.Errorf("get scripts: %w", err)
+	}
+
+	type key struct{ kind, project, database, name string }
+
+	applied := make(map[key]int64, len(existing))
+	for _, s := range existing {
+		applied[key{s.Kind, s.Project, s.Database, s.Name}] = s.ApplyTime
+	}
+
+	for i := range scripts {
+		s := scripts[i]
+
+		if t, ok := applied[key{s.Kind, s.Project, s.Database, s.Name}]; ok && t >= s.ApplyTime {
+			continue
+		}
+
+		report.Scripts++
+
+		if report.DryRun {
+			log.Printf("%s: %s/%s %s", s.Kind, s.Project, s.Database, s.Name)
+			continue
+		}
+
+		if err := mStorage.CreateProjectDB(ctx, s.Project, s.Database); err != nil {
+			return fmt.Errorf("create project db: %w", err)
+		}
+
+		if err := mStorage.SaveScript(ctx, &s); err != nil {
+			return fmt.Errorf("save %s %s/%s %s: %w", s.Kind, s.Project, s.Database, s.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Table returns the counts of the report.
+func (r TransferReport) Table() ([]string, [][]string) {
+	header := []string{"migrations", "events", "scripts", "present", "conflicts", "dry run"}
+	row := []string{strconv.Itoa(r.Written), strconv.Itoa(r.Events), strconv.Itoa(r.Scripts),
+		strconv.Itoa(r.Present), strconv.Itoa(r.Conflicts), strconv.FormatBool(r.DryRun)}
+
+	return header, [][]string{row}
+}
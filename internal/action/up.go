@@ -1,97 +1,280 @@
 package action
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/librun/migrago/internal/config"
 	"github.com/librun/migrago/internal/database"
+	"github.com/librun/migrago/internal/migration"
 	"github.com/librun/migrago/internal/storage"
 )
 
-const (
-	// migratePostfixUp is a postfix for file up migrate.
-	migratePostfixUp = "_up.sql"
+// UpOptions contains options of applying migrations.
+type UpOptions struct {
+	// Project and Database limit migrations to a project and a database, nil means all.
+	Project  *string
+	Database *string
+	// AllowOutOfOrder allows applying migrations older than the newest applied one.
+	AllowOutOfOrder bool
+	// ToolVersion is the migrago version saved in migration records.
+	ToolVersion string
+	// Description is a free-form deploy description saved in migration records.
+	Description string
+	// MigrationTimeout limits the execution of each migration, zero means no limit.
+	MigrationTimeout time.Duration
+	// Parallel is a number of databases migrated concurrently.
+	Parallel int
+	// Tenant is a glob pattern of tenants to migrate, empty means all databases and tenants.
+	Tenant string
 
-	// migratePostfixDown is a postfix for file up migrate.
-	migratePostfixDown = "_down.sql"
-)
+	// storageRelations are tables of the storage, they are not written to schema files.
+	storageRelations []string
+}
 
-// MakeUp applies migrations.
-func MakeUp(mStorage storage.Storage, cfgPath string, project, dbName *string) error {
+// MakeUp applies migrations. If the context is done, the running migration is
+// rolled back and the report of applied and not applied migrations is logged.
+// With opts.Parallel greater than one, distinct databases are migrated
+// concurrently and the report is always logged. The report is returned with
+// the error of the run.
+func MakeUp(ctx context.Context, mStorage storage.Storage, cfgPath string, opts UpOptions) (Report, error) {
 	projects := make([]string, 0)
-	if project != nil {
-		projects = append(projects, *project)
+	if opts.Project != nil {
+		projects = append(projects, *opts.Project)
 	}
 
 	databases := make([]string, 0)
-	if dbName != nil {
-		databases = append(databases, *dbName)
+	if opts.Database != nil {
+		databases = append(databases, *opts.Database)
 	}
 
 	cfg, err := config.NewConfig(cfgPath, projects, databases)
 	if err != nil {
-		return fmt.Errorf("get config: %w", err)
+		return Report{}, fmt.Errorf("get config: %w", err)
+	}
+
+	if err := expandTenants(ctx, &cfg, opts.Tenant); err != nil {
+		return Report{}, err
+	}
+
+	if opts.storageRelations, err = storage.Relations(cfgPath); err != nil {
+		return Report{}, err
 	}
 
+	// Naming errors are found before any migration is applied.
+	schemes := make(map[string]*migration.Scheme, len(cfg.Projects))
+
 	for _, project := range cfg.Projects {
-		log.Println("Project: " + project.Name)
-		log.Println("----------")
-
-		for _, migration := range project.Migrations {
-			log.Println("DB: " + migration.Database.Name)
-			// Create a bucket by the name of the project.
-			if err := mStorage.CreateProjectDB(project.Name, migration.Database.Name); err != nil {
-				return fmt.Errorf("create project db: %w", err)
-			}
+		if schemes[project.Name], err = migration.NewScheme(project.Naming); err != nil {
+			return Report{}, fmt.Errorf("project %s: %w", project.Name, err)
+		}
+	}
 
-			// All files list.
-			filesInDir, err := ioutil.ReadDir(migration.Path)
-			if err != nil {
-				return fmt.Errorf("get files list: %w", err)
+	// Common fields of all migration records.
+	base := newMigrateRecord(opts)
+
+	items := make([]upItem, 0)
+
+	for _, project := range cfg.Projects {
+		prjOpts := opts
+		prjOpts.AllowOutOfOrder = opts.AllowOutOfOrder || project.AllowOutOfOrder
+
+		for _, prjMigration := range project.Migrations {
+			items = append(items, upItem{project: project.Name, prjMigration: prjMigration, opts: prjOpts})
+		}
+	}
+
+	parallel := opts.Parallel > 1
+	if parallel {
+		mStorage = storage.Serialize(mStorage)
+	}
+
+	var (
+		report      = Report{Databases: make([]DBReport, len(items))}
+		mu          sync.Mutex
+		runErr      error
+		lastProject string
+	)
+
+	run := func(i int) {
+		item := items[i]
+		dbName := item.prjMigration.Database.Name
+		dbReport := &report.Databases[i]
+		*dbReport = DBReport{Project: item.project, Database: dbName}
+
+		// Databases after a failure are not migrated.
+		mu.Lock()
+		stop := runErr != nil
+		mu.Unlock()
+
+		if stop {
+			dbReport.Status = StatusSkipped
+			return
+		}
+
+		logger := dbLog("")
+
+		if parallel {
+			logger = dbLog(item.project + "/" + dbName + ":")
+		} else if item.project != lastProject {
+			lastProject = item.project
+
+			log.Println("Project: " + item.project)
+			log.Println("----------")
+		}
+
+		logger.Println("DB: " + dbName)
+
+		start := time.Now()
+		err := upDatabase(ctx, logger, mStorage, item.prjMigration, item.project, schemes[item.project], item.opts,
+			base, dbReport)
+		dbReport.DurationMs = time.Since(start).Milliseconds()
+		dbReport.finish(ctx, err)
+
+		if err != nil {
+			mu.Lock()
+			if runErr == nil {
+				runErr = err
 			}
+			mu.Unlock()
+		}
+	}
+
+	runGroups(groupItems(items, parallel), opts.Parallel, run)
+
+	if parallel || runErr != nil {
+		report.Log("applied")
+	}
 
-			var keys []string
+	return report, runErr
+}
 
-			for _, f := range filesInDir {
-				fileName := f.Name()
-				// Get a list of files to create migrations. Skip if the name is shorter
-				// than 8 characters.
-				if len(fileName) > 7 && fileName[len(fileName)-7:] == migratePostfixUp {
-					keys = append(keys, fileName[:len(fileName)-7])
+// upItem is a project database to migrate.
+type upItem struct {
+	project      string
+	prjMigration config.ProjectMigration
+	opts         UpOptions
+}
+
+// groupItems returns groups of item indexes which are migrated in order. In
+// parallel runs migrations of a database are grouped, so the same database is
+// never migrated concurrently; otherwise all items make one group.
+func groupItems(items []upItem, parallel bool) [][]int {
+	if !parallel {
+		group := make([]int, len(items))
+		for i := range items {
+			group[i] = i
+		}
+
+		return [][]int{group}
+	}
+
+	var groups [][]int
+
+	index := map[string]int{}
+
+	for i, item := range items {
+		name := item.prjMigration.Database.Name
+
+		if g, ok := index[name]; ok {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+
+		index[name] = len(groups)
+		groups = append(groups, []int{i})
+	}
+
+	return groups
+}
+
+// runGroups runs items of groups by workers, items of a group are run in order.
+func runGroups(groups [][]int, workers int, run func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan []int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for group := range queue {
+				for _, i := range group {
+					run(i)
 				}
 			}
+		}()
+	}
+
+	for _, group := range groups {
+		queue <- group
+	}
 
-			// Sort the list of migrations by creation date.
-			sort.Strings(keys)
+	close(queue)
+	wg.Wait()
+}
 
-			if _, err := makeMigrationInDB(mStorage, migration, project.Name, keys); err != nil {
-				return err
-			}
-		}
+// dbLog logs messages of a project database with the prefix.
+type dbLog string
+
+// Println logs the message with the prefix.
+func (p dbLog) Println(v ...interface{}) {
+	if p == "" {
+		log.Println(v...)
+		return
 	}
 
-	return nil
+	log.Println(append([]interface{}{string(p)}, v...)...)
+}
+
+// upDatabase applies migrations of the project database.
+func upDatabase(ctx context.Context, logger dbLog, mStorage storage.Storage, prjMigration config.ProjectMigration,
+	projectName string, scheme *migration.Scheme, opts UpOptions, base storage.Migrate, report *DBReport) error {
+	// Create a bucket by the name of the project.
+	if err := mStorage.CreateProjectDB(ctx, projectName, prjMigration.Database.Name); err != nil {
+		return fmt.Errorf("create project db: %w", err)
+	}
+
+	// All migrations from all directories of the database sorted by version.
+	files, err := migration.Scan(prjMigration.Paths, scheme)
+	if err != nil {
+		return err
+	}
+
+	repeatables, err := migration.ScanRepeatable(prjMigration.Paths, scheme)
+	if err != nil {
+		return err
+	}
+
+	return makeMigrationInDB(ctx, logger, mStorage, prjMigration, projectName, scheme, files, repeatables, opts, base,
+		report)
 }
 
-func makeMigrationInDB(mStorage storage.Storage, migration config.ProjectMigration, projectName string, keys []string) (int, error) {
-	defer log.Println("----------")
+func makeMigrationInDB(ctx context.Context, logger dbLog, mStorage storage.Storage, prjMigration config.ProjectMigration,
+	projectName string, scheme *migration.Scheme, files []migration.File, repeatables []migration.Repeatable,
+	opts UpOptions, base storage.Migrate, report *DBReport) error {
+	defer logger.Println("----------")
 
-	var countCompleted int
 	var countTotal int
 
-	dbc, errDB := database.NewDB(migration.Database)
+	dbc, errDB := database.NewDB(ctx, prjMigration.Database)
 	if errDB != nil {
-		return countCompleted, errDB
+		return errDB
 	}
 
 	defer func() {
-		log.Println("Completed migrations:", countCompleted, "of", countTotal)
+		logger.Println("Completed migrations:", len(report.Done), "of", countTotal)
 
 		if err := dbc.Close(); err != nil {
 			panic(err)
@@ -99,55 +282,232 @@ func makeMigrationInDB(mStorage storage.Storage, migration config.ProjectMigrati
 	}()
 
 	// Calculate total migrations to run.
-	var workKeys []string
+	var workFiles []migration.File
+
+	for _, file := range files {
+		if haveMigrate, err := mStorage.CheckMigration(ctx, projectName, prjMigration.Database.Name, file.Version); !haveMigrate {
+			if err != nil {
+				return fmt.Errorf("check migration: %w", err)
+			}
 
-	for _, version := range keys {
-		if haveMigrate, err := mStorage.CheckMigration(projectName, migration.Database.Name, version); !haveMigrate {
-			workKeys = append(workKeys, version)
-		} else if err != nil {
-			return countCompleted, fmt.Errorf("check migration: %w", err)
+			workFiles = append(workFiles, file)
+			report.Pending = append(report.Pending, file.Version)
 		}
 	}
 
-	countTotal = len(workKeys)
+	// Repeatable migrations are applied after versioned ones.
+	workRepeatables, err := changedRepeatables(ctx, mStorage, storage.ScriptRepeatable, projectName, prjMigration.Database.Name,
+		repeatables)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range workRepeatables {
+		report.Pending = append(report.Pending, run.Name)
+	}
+
+	countTotal = len(workFiles) + len(workRepeatables)
 
-	for _, version := range workKeys {
-		content, err := ioutil.ReadFile(migration.Path + version + migratePostfixUp)
+	// A baseline replaces the squashed migrations only if none of them is applied.
+	for _, file := range workFiles {
+		squashed, err := file.Squashed()
 		if err != nil {
-			return countCompleted, err
+			return err
 		}
 
-		query := string(content)
-		if strings.TrimSpace(query) != "" {
-			// Executing all requests from the current file.
-			if errExec := dbc.Exec(query); errExec != nil {
-				log.Println("migration fail: " + version)
-				return countCompleted, errExec
+		if len(squashed) > 0 {
+			if _, err := getSquashState(ctx, mStorage, scheme, projectName, prjMigration.Database.Name, file.Version,
+				squashed); err != nil {
+				return err
 			}
 		}
+	}
+
+	outOfOrder, err := findOutOfOrder(ctx, mStorage, scheme, projectName, prjMigration.Database.Name, workFiles)
+	if err != nil {
+		return err
+	}
 
-		post := &storage.Migrate{
-			Project:   projectName,
-			Database:  migration.Database.Name,
-			Version:   version,
-			ApplyTime: time.Now().UTC().Unix(),
-			RollFlag:  true,
+	if len(outOfOrder) > 0 && !opts.AllowOutOfOrder {
+		versions := make([]string, 0, len(outOfOrder))
+		for _, file := range workFiles {
+			if outOfOrder[file.Version] {
+				versions = append(versions, file.Version)
+			}
+		}
+
+		return fmt.Errorf("migrations older than the last applied one found (use --allow-out-of-order "+
+			"to apply them): %s", strings.Join(versions, ", "))
+	}
+
+	for _, file := range workFiles {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %w", err)
 		}
 
+		version := file.Version
+
+		query, err := file.ReadUp()
+		if err != nil {
+			return err
+		}
+
+		post := base
+		post.Project = projectName
+		post.Database = prjMigration.Database.Name
+		post.Version = version
 		// If the file with the ending down.sql does not exist, then indicate that
 		// this migration is not rolling back.
-		if _, err := os.Stat(migration.Path + version + migratePostfixDown); os.IsNotExist(err) {
-			post.RollFlag = false
+		post.RollFlag = file.Rollback()
+		post.OutOfOrder = outOfOrder[version]
+		post.Checksum = migration.Checksum(query)
+
+		start := time.Now()
+
+		report.Pending = report.Pending[1:]
+
+		if !migration.IsEmpty(query) {
+			// Executing all requests from the current file.
+			if errExec := execMigration(ctx, dbc, query, opts.MigrationTimeout); errExec != nil {
+				logger.Println("migration fail: " + version)
+
+				report.Failed = version
+				report.addResult(version, start, errExec)
+
+				post.ApplyTime = time.Now().UTC().Unix()
+				post.DurationMs = time.Since(start).Milliseconds()
+				post.Failed = true
+				post.Error = errExec.Error()
+
+				saveFailure(logger, mStorage, &post, start)
+
+				return errExec
+			}
+		}
+
+		post.ApplyTime = time.Now().UTC().Unix()
+		post.DurationMs = time.Since(start).Milliseconds()
+
+		resCtx, cancel := resultContext()
+		err = mStorage.Up(resCtx, &post)
+
+		if err == nil {
+			err = addEvent(resCtx, mStorage, newEvent(storage.EventApply, &post), start)
+		}
+
+		cancel()
+
+		if err != nil {
+			err = fmt.Errorf("migration %s is applied, but its record is not saved: %w", version, err)
+
+			logger.Println("migration fail: " + version)
+			report.Failed = version
+			report.addResult(version, start, err)
+
+			return err
 		}
 
-		if err := mStorage.Up(post); err != nil {
-			log.Println("migration fail: " + version)
-			return countCompleted, fmt.Errorf("storage up: %w", err)
+		if post.OutOfOrder {
+			logger.Println("migration success (out of order): " + version)
+		} else {
+			logger.Println("migration success: " + version)
 		}
 
-		log.Println("migration success: " + version)
-		countCompleted++
+		report.Done = append(report.Done, version)
+		report.addResult(version, start, nil)
 	}
 
-	return countCompleted, nil
+	for _, run := range workRepeatables {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %w", err)
+		}
+
+		report.Pending = report.Pending[1:]
+
+		if err := applyRepeatable(ctx, logger, mStorage, dbc, projectName, prjMigration.Database.Name, run,
+			opts.MigrationTimeout, base, report); err != nil {
+			return err
+		}
+	}
+
+	// The schema file shows the net effect of the applied migrations.
+	if schemaFile := prjMigration.Database.SchemaFile; schemaFile != "" && len(report.Done) > 0 {
+		if err := writeSchema(ctx, dbc, schemaFile, opts.storageRelations); err != nil {
+			return fmt.Errorf("migrations are applied, but the schema file is not written: %w", err)
+		}
+
+		logger.Println("schema dumped: " + schemaFile)
+	}
+
+	return nil
+}
+
+// saveFailure saves the record and the event of a failed migration.
+func saveFailure(logger dbLog, mStorage storage.Storage, post *storage.Migrate, start time.Time) {
+	resCtx, cancel := resultContext()
+	defer cancel()
+
+	if err := mStorage.Up(resCtx, post); err != nil {
+		logger.Println("save failed migration: " + err.Error())
+	}
+
+	if err := addEvent(resCtx, mStorage, newEvent(storage.EventFailure, post), start); err != nil {
+		logger.Println(err)
+	}
+}
+
+// execMigration executes the migration query with the migration timeout.
+func execMigration(ctx context.Context, dbc *database.DB, query string, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+
+		defer cancel()
+	}
+
+	err := dbc.Exec(ctx, query)
+
+	// Drivers report cancelled statements by their own errors.
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		err = fmt.Errorf("%v: %w", err, ctx.Err())
+	}
+
+	return err
+}
+
+// findOutOfOrder returns pending migrations with versions older than the newest applied migration.
+func findOutOfOrder(ctx context.Context, mStorage storage.Storage, scheme *migration.Scheme, projectName, dbName string,
+	pending []migration.File) (map[string]bool, error) {
+	last := 1
+
+	applied, err := getLast(ctx, mStorage, scheme, projectName, dbName, false, &last)
+	if err != nil {
+		return nil, fmt.Errorf("get last migration: %w", err)
+	}
+
+	outOfOrder := map[string]bool{}
+
+	if len(applied) == 0 {
+		return outOfOrder, nil
+	}
+
+	for _, file := range pending {
+		if scheme.Less(file.Version, applied[0].Version) {
+			outOfOrder[file.Version] = true
+		}
+	}
+
+	return outOfOrder, nil
+}
+
+// newMigrateRecord returns a migration record with fields common for all migrations of a run.
+func newMigrateRecord(opts UpOptions) storage.Migrate {
+	record := storage.Migrate{
+		ToolVersion: opts.ToolVersion,
+		Description: opts.Description,
+	}
+
+	record.AppliedBy, record.Host = currentUserHost()
+
+	return record
 }
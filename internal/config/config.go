@@ -2,15 +2,15 @@ package config
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-
-	"gopkg.in/yaml.v2"
+	"regexp"
+	"strings"
+	"time"
 )
 
 type (
-	// Config struct Config yaml file.
+	// Config struct Config file.
 	Config struct {
 		Projects  []Project
 		Databases []Database
@@ -20,11 +20,49 @@ type (
 	Project struct {
 		Name       string
 		Migrations []ProjectMigration
+		Naming     Naming
+		// AllowOutOfOrder allows applying migrations older than the newest applied one.
+		AllowOutOfOrder bool
+	}
+
+	// Naming describes migration file names of a project. Empty fields are
+	// taken from the preset.
+	Naming struct {
+		// Preset is a naming of well-known tool: migrago (default), golang-migrate, flyway, goose.
+		Preset string `yaml:"preset"`
+		// Up and Down are regular expressions for file names with a (?P<version>...) group.
+		Up   string `yaml:"up"`
+		Down string `yaml:"down"`
+		// Single is a regular expression for files with both up and down sections.
+		Single string `yaml:"single"`
+		// Repeat is a regular expression for repeatable migrations, the version group is their name.
+		Repeat string `yaml:"repeat"`
+		// Compare is a version comparison rule: lexicographic, numeric, semver.
+		Compare string `yaml:"compare"`
+		// CreateUp and CreateDown are templates of new file names with {version} and {name} placeholders.
+		CreateUp     string `yaml:"create_up"`
+		CreateDown   string `yaml:"create_down"`
+		CreateSingle string `yaml:"create_single"`
+		// VersionFormat is a Go time layout for versions of new migrations.
+		VersionFormat string `yaml:"version_format"`
+		// Sequence makes new migrations use the next zero-padded sequence number
+		// instead of a timestamp.
+		Sequence       bool `yaml:"sequence"`
+		SequenceDigits int  `yaml:"sequence_digits"`
+	}
+
+	// Templates contains paths to text/template files for new migrations.
+	Templates struct {
+		Up     string `yaml:"up"`
+		Down   string `yaml:"down"`
+		Single string `yaml:"single"`
 	}
 
 	// ProjectMigration struct relation Project with Database.
 	ProjectMigration struct {
-		Path     string
+		Paths []string
+		// Seeds are directories of seed data, they are not reverted by down.
+		Seeds    []string
 		Database *Database
 	}
 
@@ -34,43 +72,101 @@ type (
 		TypeDB string
 		DSN    string
 		Schema string
+		// SchemaFile is a path of the schema dump regenerated after migrations.
+		SchemaFile string
+		Connection
+		// Tenants is set for a database which is expanded to a database of every
+		// tenant, see ForTenant.
+		Tenants *Tenants
+	}
+
+	// Tenants are sources of tenant names of a database. A query is run against
+	// the Catalog database and returns tenant names in the first column.
+	Tenants struct {
+		List    []string
+		File    string
+		Query   string
+		Catalog *Database
+	}
+
+	// Connection contains session settings and connection pool options of a database.
+	Connection struct {
+		// Session is run on every new connection before migrations execute.
+		Session Session `yaml:"session"`
+		// MaxOpenConns, MaxIdleConns and ConnMaxLifetime limit the connection pool, zero means the default.
+		MaxOpenConns    int           `yaml:"max_open_conns"`
+		MaxIdleConns    int           `yaml:"max_idle_conns"`
+		ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+		// ConnectTimeout limits every connection attempt. With ConnectTimeout or
+		// ConnectRetries the database is checked when it is opened, and a failed
+		// check is retried ConnectRetries times after ConnectRetryDelay.
+		ConnectTimeout    time.Duration `yaml:"connect_timeout"`
+		ConnectRetries    int           `yaml:"connect_retries"`
+		ConnectRetryDelay time.Duration `yaml:"connect_retry_delay"`
+	}
+
+	// Session is a list of statements or a map of settings of a database session.
+	Session struct {
+		Statements []string
+		Settings   map[string]string
 	}
 
-	// YAMLConfig takes configuration values from YAML file.
+	// YAMLConfig takes configuration values from config file (YAML, JSON or TOML).
 	YAMLConfig struct {
 		Projects  map[string]YAMLConfigProject  `yaml:"projects"`
 		Databases map[string]YAMLConfigDatabase `yaml:"databases"`
+		// Templates for new migrations by database type.
+		Templates map[string]Templates `yaml:"templates"`
 	}
 
 	// YAMLConfigProject is a block for parse projects in YAML config file.
 	YAMLConfigProject struct {
-		Migrations []map[string]string `yaml:"migrations"`
+		Migrations []map[string]YAMLPaths `yaml:"migrations"`
+		Seeds      []map[string]YAMLPaths `yaml:"seeds"`
+		Naming     Naming                 `yaml:"naming"`
+		Templates  Templates              `yaml:"templates"`
+		// AllowOutOfOrder allows applying migrations older than the newest applied one.
+		AllowOutOfOrder bool `yaml:"allow_out_of_order"`
 	}
 
+	// YAMLPaths is a list of migration directories (or glob patterns) for a database.
+	// It can be written in config as a single string or as a list of strings.
+	YAMLPaths []string
+
 	// YAMLConfigDatabase is a block for parse databases in YAML config file.
 	YAMLConfigDatabase struct {
-		Type   string `yaml:"type"`
-		DSN    string `yaml:"dsn"`
-		Schema string `yaml:"schema"`
+		Type       string `yaml:"type"`
+		DSN        string `yaml:"dsn"`
+		Schema     string `yaml:"schema"`
+		SchemaFile string `yaml:"schema_file"`
+		Connection `yaml:",inline"`
+		Tenants    *YAMLTenants `yaml:"tenants"`
 	}
-)
 
-// NewConfig init Config from file.
-func NewConfig(path string, projects, databases []string) (Config, error) {
-	configFile, err := os.Open(path)
-	if err != nil {
-		return Config{}, fmt.Errorf("config open: %w", err)
+	// YAMLTenants is a block for parse tenants of a database. It can be written
+	// in config as a list of tenant names or as a map of sources.
+	YAMLTenants struct {
+		List  []string `yaml:"list"`
+		File  string   `yaml:"file"`
+		Query string   `yaml:"query"`
+		// Catalog is a name of a database the query is run against, by default
+		// the database itself without its schema and session.
+		Catalog string `yaml:"catalog"`
 	}
-	defer configFile.Close()
+)
 
-	configByte, err := ioutil.ReadAll(configFile)
-	if err != nil {
-		return Config{}, fmt.Errorf("config read: %w", err)
-	}
+// TenantPlaceholder is replaced by a tenant name in the DSN, the schema, the
+// schema file and the session of a database with tenants.
+const TenantPlaceholder = "{tenant}"
 
+// tenantNameRe matches tenant names, they are used in DSNs and storage records.
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// NewConfig init Config from file.
+func NewConfig(path string, projects, databases []string) (Config, error) {
 	cfg := YAMLConfig{}
-	if err := yaml.Unmarshal(configByte, &cfg); err != nil {
-		return Config{}, fmt.Errorf("config format: %w", err)
+	if err := Decode(path, &cfg); err != nil {
+		return Config{}, err
 	}
 
 	projectDelete := false
@@ -86,17 +182,29 @@ func NewConfig(path string, projects, databases []string) (Config, error) {
 
 	dbDelete := false
 	dbCurrent := map[string]bool{}
+	// A tenant of a database is selected by the name "database/tenant".
+	dbTenants := map[string][]string{}
 
 	if len(databases) > 0 {
 		dbDelete = true
 
-		for _, projectName := range databases {
-			dbCurrent[projectName] = true
+		for _, dbName := range databases {
+			if i := strings.Index(dbName, "/"); i >= 0 {
+				dbTenants[dbName[:i]] = append(dbTenants[dbName[:i]], dbName[i+1:])
+				dbName = dbName[:i]
+			}
+
+			dbCurrent[dbName] = true
 		}
 	}
 
-	conf := Config{
-		Databases: cfg.parseDatabases(dbCurrent, dbDelete),
+	var (
+		conf Config
+		err  error
+	)
+
+	if conf.Databases, err = cfg.parseDatabases(dbCurrent, dbDelete); err != nil {
+		return conf, err
 	}
 
 	conf.Projects, err = cfg.parseProjects(conf, projectCurrent, projectDelete, dbCurrent, dbDelete)
@@ -104,10 +212,14 @@ func NewConfig(path string, projects, databases []string) (Config, error) {
 		return conf, err
 	}
 
+	if err := conf.selectTenants(dbTenants); err != nil {
+		return conf, err
+	}
+
 	return conf, nil
 }
 
-// GetDB gets database object by name.
+// GetDB gets database object by name. A tenant of a database is got by the name "database/tenant".
 func (c *Config) GetDB(name string) (Database, error) {
 	for _, db := range c.Databases {
 		if db.Name == name {
@@ -115,6 +227,14 @@ func (c *Config) GetDB(name string) (Database, error) {
 		}
 	}
 
+	if i := strings.Index(name, "/"); i >= 0 {
+		for _, db := range c.Databases {
+			if db.Name == name[:i] && db.Tenants != nil {
+				return db.ForTenant(name[i+1:])
+			}
+		}
+	}
+
 	return Database{}, fmt.Errorf("database %s not found in Databases", name)
 }
 
@@ -141,9 +261,14 @@ func (p *Project) GetDB(name string) (Database, error) {
 }
 
 // GetProjectMigration gets relation database in project by database name.
+// A database with tenants can not be used as is, one of its tenants is required.
 func (p *Project) GetProjectMigration(dbName string) (ProjectMigration, error) {
 	for _, migration := range p.Migrations {
 		if migration.Database.Name == dbName {
+			if migration.Database.Tenants != nil {
+				return ProjectMigration{}, fmt.Errorf("database %s has tenants, select one as %s/<tenant>", dbName, dbName)
+			}
+
 			return migration, nil
 		}
 	}
@@ -151,7 +276,103 @@ func (p *Project) GetProjectMigration(dbName string) (ProjectMigration, error) {
 	return ProjectMigration{}, fmt.Errorf("database %s not found in Project %s Databases", dbName, p.Name)
 }
 
-func (cfg *YAMLConfig) parseDatabases(dbCurrent map[string]bool, dbDelete bool) []Database {
+// ForTenant returns the database of the tenant: the tenant placeholder is
+// replaced in the DSN, the schema, the schema file and the session, and the
+// tenant is tracked in the storage as the database "database/tenant".
+func (db Database) ForTenant(tenant string) (Database, error) {
+	if !tenantNameRe.MatchString(tenant) {
+		return Database{}, fmt.Errorf("database %s: invalid tenant name %q", db.Name, tenant)
+	}
+
+	replace := func(s string) string {
+		return strings.ReplaceAll(s, TenantPlaceholder, tenant)
+	}
+
+	tenantDB := db
+	tenantDB.Name = db.Name + "/" + tenant
+	tenantDB.DSN = replace(db.DSN)
+	tenantDB.Schema = replace(db.Schema)
+	tenantDB.SchemaFile = replace(db.SchemaFile)
+	tenantDB.Tenants = nil
+
+	tenantDB.Session.Statements = make([]string, 0, len(db.Session.Statements))
+	for _, statement := range db.Session.Statements {
+		tenantDB.Session.Statements = append(tenantDB.Session.Statements, replace(statement))
+	}
+
+	if db.Session.Settings != nil {
+		tenantDB.Session.Settings = make(map[string]string, len(db.Session.Settings))
+		for name, value := range db.Session.Settings {
+			tenantDB.Session.Settings[name] = replace(value)
+		}
+	}
+
+	return tenantDB, nil
+}
+
+// hasTenantPlaceholder reports whether the tenant placeholder is used in the
+// DSN, the schema or the session of the database.
+func (db Database) hasTenantPlaceholder() bool {
+	if strings.Contains(db.DSN, TenantPlaceholder) || strings.Contains(db.Schema, TenantPlaceholder) {
+		return true
+	}
+
+	for _, statement := range db.Session.Statements {
+		if strings.Contains(statement, TenantPlaceholder) {
+			return true
+		}
+	}
+
+	for _, value := range db.Session.Settings {
+		if strings.Contains(value, TenantPlaceholder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectTenants replaces databases with tenants in project migrations by the
+// databases of the selected tenants.
+func (c *Config) selectTenants(dbTenants map[string][]string) error {
+	if len(dbTenants) == 0 {
+		return nil
+	}
+
+	for i := range c.Projects {
+		migrations := make([]ProjectMigration, 0, len(c.Projects[i].Migrations))
+
+		for _, migration := range c.Projects[i].Migrations {
+			tenants, ok := dbTenants[migration.Database.Name]
+			if !ok {
+				migrations = append(migrations, migration)
+				continue
+			}
+
+			if migration.Database.Tenants == nil {
+				return fmt.Errorf("database %s has no tenants", migration.Database.Name)
+			}
+
+			for _, tenant := range tenants {
+				db, err := migration.Database.ForTenant(tenant)
+				if err != nil {
+					return err
+				}
+
+				tenantMigration := migration
+				tenantMigration.Database = &db
+
+				migrations = append(migrations, tenantMigration)
+			}
+		}
+
+		c.Projects[i].Migrations = migrations
+	}
+
+	return nil
+}
+
+func (cfg *YAMLConfig) parseDatabases(dbCurrent map[string]bool, dbDelete bool) ([]Database, error) {
 	databases := make([]Database, 0, len(cfg.Databases))
 
 	for dbName, db := range cfg.Databases {
@@ -165,15 +386,79 @@ func (cfg *YAMLConfig) parseDatabases(dbCurrent map[string]bool, dbDelete bool)
 		//	 return Config{}, fmt.Errorf("type Database %s not support", db.Type)
 		// }
 
-		databases = append(databases, Database{
-			Name:   dbName,
-			TypeDB: db.Type,
-			DSN:    db.DSN,
-			Schema: db.Schema,
-		})
+		database := Database{
+			Name:       dbName,
+			TypeDB:     db.Type,
+			DSN:        db.DSN,
+			Schema:     db.Schema,
+			SchemaFile: db.SchemaFile,
+			Connection: db.Connection,
+		}
+
+		if db.Tenants != nil {
+			tenants, err := cfg.parseTenants(database, db.Tenants)
+			if err != nil {
+				return nil, err
+			}
+
+			database.Tenants = tenants
+		}
+
+		databases = append(databases, database)
 	}
 
-	return databases
+	return databases, nil
+}
+
+// parseTenants returns tenant sources of the database and finds its catalog database.
+func (cfg *YAMLConfig) parseTenants(db Database, yamlTenants *YAMLTenants) (*Tenants, error) {
+	// Without the placeholder every tenant would be migrated in the same database.
+	if !db.hasTenantPlaceholder() {
+		return nil, fmt.Errorf("database %s: tenants require %s in the dsn, the schema or the session", db.Name, TenantPlaceholder)
+	}
+
+	tenants := &Tenants{
+		List:  yamlTenants.List,
+		File:  yamlTenants.File,
+		Query: yamlTenants.Query,
+	}
+
+	if tenants.Query == "" {
+		return tenants, nil
+	}
+
+	if yamlTenants.Catalog == "" {
+		if strings.Contains(db.DSN, TenantPlaceholder) {
+			return nil, fmt.Errorf("database %s: tenants query requires a catalog database", db.Name)
+		}
+
+		catalog := db
+		catalog.Schema = ""
+		catalog.Session = Session{}
+		tenants.Catalog = &catalog
+
+		return tenants, nil
+	}
+
+	// The catalog is found among all databases, it may be not selected.
+	catalog, ok := cfg.Databases[yamlTenants.Catalog]
+	if !ok {
+		return nil, fmt.Errorf("database %s: catalog database %s not found in Databases", db.Name, yamlTenants.Catalog)
+	}
+
+	if catalog.Tenants != nil {
+		return nil, fmt.Errorf("database %s: catalog database %s has tenants", db.Name, yamlTenants.Catalog)
+	}
+
+	tenants.Catalog = &Database{
+		Name:       yamlTenants.Catalog,
+		TypeDB:     catalog.Type,
+		DSN:        catalog.DSN,
+		Schema:     catalog.Schema,
+		Connection: catalog.Connection,
+	}
+
+	return tenants, nil
 }
 
 func (cfg *YAMLConfig) parseProjects(conf Config, projectCurrent map[string]bool, projectDelete bool, dbCurrent map[string]bool, dbDelete bool) ([]Project, error) {
@@ -186,34 +471,58 @@ func (cfg *YAMLConfig) parseProjects(conf Config, projectCurrent map[string]bool
 		}
 
 		project := Project{
-			Name: prjName,
+			Name:            prjName,
+			Naming:          prjMigration.Naming,
+			AllowOutOfOrder: prjMigration.AllowOutOfOrder,
 		}
 
 		for _, migration := range prjMigration.Migrations {
-			for dbName, path := range migration {
+			for dbName, paths := range migration {
 				// If this database is not needed, skip it.
 				if _, ok := dbCurrent[dbName]; dbDelete && !ok {
 					continue
 				}
 
-				if db, err := conf.GetDB(dbName); err == nil {
-					// Check that the path to migrations exists.
-					if fi, err := os.Stat(path); os.IsNotExist(err) || !fi.IsDir() {
-						return projects, fmt.Errorf("directory %s not exists", path)
-					}
-
-					path, err = filepath.Abs(path)
-					if err != nil {
-						return projects, fmt.Errorf("get directory %s absolute path: %w", path, err)
-					}
-
-					project.Migrations = append(project.Migrations, ProjectMigration{
-						Path:     path + "/",
-						Database: &db,
-					})
-				} else {
+				db, err := conf.GetDB(dbName)
+				if err != nil {
 					return projects, fmt.Errorf("database %s not found in Databases", dbName)
 				}
+
+				dirs, err := expandPaths(paths)
+				if err != nil {
+					return projects, err
+				}
+
+				// The same database can be listed several times, merge its directories.
+				if i := project.migrationIndex(dbName); i >= 0 {
+					project.Migrations[i].Paths = append(project.Migrations[i].Paths, dirs...)
+					continue
+				}
+
+				project.Migrations = append(project.Migrations, ProjectMigration{
+					Paths:    dirs,
+					Database: &db,
+				})
+			}
+		}
+
+		for _, seed := range prjMigration.Seeds {
+			for dbName, paths := range seed {
+				if _, ok := dbCurrent[dbName]; dbDelete && !ok {
+					continue
+				}
+
+				i := project.migrationIndex(dbName)
+				if i < 0 {
+					return projects, fmt.Errorf("database %s of seeds not found in Project %s migrations", dbName, prjName)
+				}
+
+				dirs, err := expandPaths(paths)
+				if err != nil {
+					return projects, err
+				}
+
+				project.Migrations[i].Seeds = append(project.Migrations[i].Seeds, dirs...)
 			}
 		}
 
@@ -222,3 +531,114 @@ func (cfg *YAMLConfig) parseProjects(conf Config, projectCurrent map[string]bool
 
 	return projects, nil
 }
+
+// UnmarshalYAML parses a single path or a list of paths.
+func (p *YAMLPaths) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		*p = YAMLPaths{path}
+		return nil
+	}
+
+	var paths []string
+	if err := unmarshal(&paths); err != nil {
+		return err
+	}
+
+	*p = paths
+
+	return nil
+}
+
+// UnmarshalYAML parses a list of statements or a map of settings.
+func (s *Session) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var statements []string
+	if err := unmarshal(&statements); err == nil {
+		*s = Session{Statements: statements}
+		return nil
+	}
+
+	var settings map[string]string
+	if err := unmarshal(&settings); err != nil {
+		return err
+	}
+
+	*s = Session{Settings: settings}
+
+	return nil
+}
+
+// UnmarshalYAML parses a list of tenant names or a map of tenant sources.
+func (t *YAMLTenants) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*t = YAMLTenants{List: list}
+		return nil
+	}
+
+	// A distinct type prevents the recursion of UnmarshalYAML.
+	type tenants YAMLTenants
+
+	var sources tenants
+	if err := unmarshal(&sources); err != nil {
+		return err
+	}
+
+	*t = YAMLTenants(sources)
+
+	return nil
+}
+
+// migrationIndex returns index of the database in project migrations or -1.
+func (p *Project) migrationIndex(dbName string) int {
+	for i, migration := range p.Migrations {
+		if migration.Database.Name == dbName {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// expandPaths expands glob patterns and returns absolute paths of migration directories.
+func expandPaths(patterns []string) ([]string, error) {
+	dirs := make([]string, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("directory pattern %s: %w", pattern, err)
+			}
+
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("directory pattern %s matches nothing", pattern)
+			}
+		}
+
+		for _, path := range matches {
+			// Check that the path to migrations exists.
+			fi, err := os.Stat(path)
+			if err != nil || !fi.IsDir() {
+				// Files matched by a pattern are skipped, only directories are used.
+				if len(matches) > 1 || path != pattern {
+					continue
+				}
+
+				return nil, fmt.Errorf("directory %s not exists", path)
+			}
+
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return nil, fmt.Errorf("get directory %s absolute path: %w", path, err)
+			}
+
+			dirs = append(dirs, abs+"/")
+		}
+	}
+
+	return dirs, nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDatabasesTenantPlaceholder(t *testing.T) {
+	tests := []struct {
+		name string
+		db   YAMLConfigDatabase
+		ok   bool
+	}{
+		{"dsn", YAMLConfigDatabase{DSN: "postgres://localhost/{tenant}"}, true},
+		{"schema", YAMLConfigDatabase{DSN: "postgres://localhost/shop", Schema: "tenant_{tenant}"}, true},
+		{"statements", YAMLConfigDatabase{
+			DSN:        "postgres://localhost/shop",
+			Connection: Connection{Session: Session{Statements: []string{"SET ROLE {tenant}"}}},
+		}, true},
+		{"settings", YAMLConfigDatabase{
+			DSN:        "postgres://localhost/shop",
+			Connection: Connection{Session: Session{Settings: map[string]string{"app.tenant": "{tenant}"}}},
+		}, true},
+		{"none", YAMLConfigDatabase{DSN: "postgres://localhost/shop", Schema: "public"}, false},
+	}
+
+	for _, tt := range tests {
+		tt.db.Type = "postgres"
+		tt.db.Tenants = &YAMLTenants{List: []string{"alpha", "beta"}}
+
+		cfg := YAMLConfig{Databases: map[string]YAMLConfigDatabase{"shop": tt.db}}
+
+		_, err := cfg.parseDatabases(nil, false)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), TenantPlaceholder)) {
+			t.Errorf("%s: err = %v, want a missing placeholder error", tt.name, err)
+		}
+	}
+}
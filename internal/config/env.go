@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Environment variables used to configure migrago without a config file.
+const (
+	EnvStorageType        = "MIGRAGO_STORAGE_TYPE"
+	EnvStorageDSN         = "MIGRAGO_STORAGE_DSN"
+	EnvStorageSchema      = "MIGRAGO_STORAGE_SCHEMA"
+	EnvStoragePath        = "MIGRAGO_STORAGE_PATH"
+	EnvStorageTable       = "MIGRAGO_STORAGE_TABLE"
+	EnvStorageTableSchema = "MIGRAGO_STORAGE_TABLE_SCHEMA"
+	EnvProject            = "MIGRAGO_PROJECT"
+	EnvDBName             = "MIGRAGO_DB_NAME"
+	EnvDBType             = "MIGRAGO_DB_TYPE"
+	EnvDBDSN              = "MIGRAGO_DB_DSN"
+	EnvDBSchema           = "MIGRAGO_DB_SCHEMA"
+	EnvMigrations         = "MIGRAGO_MIGRATIONS_DIR"
+)
+
+// Default names of the project and database configured from environment.
+const (
+	envProjectDefault = "default"
+	envDBNameDefault  = "default"
+)
+
+// envRequired lists the variables that must be set to configure migrago from environment.
+var envRequired = []string{EnvDBType, EnvDBDSN, EnvMigrations}
+
+// CheckEnv checks that the environment contains a complete configuration.
+func CheckEnv() error {
+	var missing []string
+
+	for _, name := range envRequired {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("config file is not set and environment config is incomplete: %s not set",
+			strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// readEnv builds a YAML config document from environment variables.
+func readEnv() ([]byte, error) {
+	if err := CheckEnv(); err != nil {
+		return nil, err
+	}
+
+	project := envDefault(EnvProject, envProjectDefault)
+	dbName := envDefault(EnvDBName, envDBNameDefault)
+
+	doc := map[string]interface{}{
+		"migration_storage": map[string]string{
+			"storage_type": os.Getenv(EnvStorageType),
+			"dsn":          os.Getenv(EnvStorageDSN),
+			"schema":       os.Getenv(EnvStorageSchema),
+			"path":         os.Getenv(EnvStoragePath),
+			"table":        os.Getenv(EnvStorageTable),
+			"table_schema": os.Getenv(EnvStorageTableSchema),
+		},
+		"projects": map[string]interface{}{
+			project: map[string]interface{}{
+				"migrations": []map[string]string{{dbName: os.Getenv(EnvMigrations)}},
+			},
+		},
+		"databases": map[string]interface{}{
+			dbName: map[string]string{
+				"type":   os.Getenv(EnvDBType),
+				"dsn":    os.Getenv(EnvDBDSN),
+				"schema": os.Getenv(EnvDBSchema),
+			},
+		},
+	}
+
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("config env: %w", err)
+	}
+
+	return content, nil
+}
+
+func envDefault(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return value
+}
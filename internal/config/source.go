@@ -0,0 +1,178 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// PathStdin is a config path meaning that the configuration is read from stdin.
+const PathStdin = "-"
+
+// Supported configuration file formats.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// stdin can be read only once, but the configuration is parsed by several
+// packages, so its content is cached.
+var (
+	stdinOnce    sync.Once
+	stdinContent []byte
+	errStdin     error
+)
+
+// Decode reads the configuration from path (or environment variables if path
+// is empty) and decodes it into v.
+// The format is chosen by the file extension (.yaml/.yml, .json, .toml).
+// JSON and TOML documents are converted to YAML before decoding, so all
+// formats share the `yaml` struct tags and have identical semantics.
+func Decode(path string, v interface{}) error {
+	content, format, err := read(path)
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(content)) == 0 {
+		return errors.New("config format: empty config")
+	}
+
+	switch format {
+	case FormatJSON:
+		// JSON is decoded by its own rules, so strings like "on" stay strings.
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			return fmt.Errorf("config format: %w", err)
+		}
+
+		if content, err = yaml.Marshal(jsonNumbers(doc)); err != nil {
+			return fmt.Errorf("config format: %w", err)
+		}
+	case FormatTOML:
+		doc := map[string]interface{}{}
+		if _, err := toml.Decode(string(content), &doc); err != nil {
+			return fmt.Errorf("config format: %w", err)
+		}
+
+		if content, err = yaml.Marshal(doc); err != nil {
+			return fmt.Errorf("config format: %w", err)
+		}
+	}
+
+	if err := yaml.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("config format: %w", err)
+	}
+
+	return nil
+}
+
+// read returns the raw configuration and its format.
+// An empty path means that the configuration is taken from environment variables.
+func read(path string) ([]byte, string, error) {
+	if path == "" {
+		content, err := readEnv()
+
+		return content, FormatYAML, err
+	}
+
+	if path == PathStdin {
+		stdinOnce.Do(func() {
+			stdinContent, errStdin = ioutil.ReadAll(os.Stdin)
+		})
+
+		if errStdin != nil {
+			return nil, "", fmt.Errorf("config read: %w", errStdin)
+		}
+
+		return stdinContent, detectFormat(stdinContent), nil
+	}
+
+	configFile, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("config open: %w", err)
+	}
+	defer configFile.Close()
+
+	content, err := ioutil.ReadAll(configFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("config read: %w", err)
+	}
+
+	return content, formatByExt(path), nil
+}
+
+// formatByExt returns the config format by the file extension.
+// Files with other extensions are parsed as YAML for backward compatibility.
+func formatByExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// detectFormat guesses the format of a config without a file name (stdin).
+// JSON is recognized by its first byte, TOML is tried for other non-empty input.
+func detectFormat(content []byte) string {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return FormatYAML
+	}
+
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		return FormatJSON
+	}
+
+	if _, err := toml.Decode(string(trimmed), &map[string]interface{}{}); err == nil {
+		return FormatTOML
+	}
+
+	return FormatYAML
+}
+
+// jsonNumbers replaces JSON numbers in the decoded document by integers, or by
+// floats if they are not integers, so they are written to YAML as numbers.
+func jsonNumbers(doc interface{}) interface{} {
+	switch value := doc.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = jsonNumbers(v)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = jsonNumbers(v)
+		}
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+
+		if n, err := strconv.ParseUint(value.String(), 10, 64); err == nil {
+			return n
+		}
+
+		if n, err := value.Float64(); err == nil {
+			return n
+		}
+	}
+
+	return doc
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// connector opens connections of a driver and runs session statements on every
+// new connection, so session settings apply to all connections of the pool.
+type connector struct {
+	base    driver.Connector
+	session []string
+}
+
+// dsnConnector opens connections of a driver without context support.
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+// Connect opens a connection by the DSN.
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+// Driver returns the underlying driver of the connector.
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// newBaseConnector returns a connector of the driver which respects the
+// connect context if the driver supports it.
+func newBaseConnector(typeDB string, drv driver.Driver, dsn string) (driver.Connector, error) {
+	if drvCtx, ok := drv.(driver.DriverContext); ok {
+		return drvCtx.OpenConnector(dsn)
+	}
+
+	// The postgres driver supports the context by its own connector only.
+	if typeDB == dbTypePostgres {
+		return pq.NewConnector(dsn)
+	}
+
+	return &dsnConnector{driver: drv, dsn: dsn}, nil
+}
+
+// Connect opens a connection and runs the session statements on it.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, query := range c.session {
+		if err := execConn(ctx, conn, query); err != nil {
+			if errClose := conn.Close(); errClose != nil {
+				return nil, fmt.Errorf("close: %w", errClose)
+			}
+
+			return nil, fmt.Errorf("session %q: %w", query, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// Driver returns the underlying driver of the connector.
+func (c *connector) Driver() driver.Driver {
+	return c.base.Driver()
+}
+
+// execConn executes the query on a driver connection.
+func execConn(ctx context.Context, conn driver.Conn, query string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, query, nil)
+		if !errors.Is(err, driver.ErrSkip) {
+			return err
+		}
+	}
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.Exec(nil); err != nil {
+		if errClose := stmt.Close(); errClose != nil {
+			return fmt.Errorf("close: %w", errClose)
+		}
+
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// Open opens a database of the type. The session statements are run on every
+// new connection before it is used.
+func Open(typeDB, dsn string, session []string) (*sql.DB, error) {
+	// sql.Open does not connect, it is used to get the registered driver.
+	db, err := sql.Open(typeDB, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	drv := db.Driver()
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+
+	base, err := newBaseConnector(typeDB, drv, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(&connector{base: base, session: session}), nil
+}
+
+// SchemaSession returns statements selecting the schema for a connection of
+// the database type. A postgres schema can be a comma-separated search path,
+// its names are quoted except $user.
+func SchemaSession(typeDB, schema string) []string {
+	if schema == "" {
+		return nil
+	}
+
+	switch typeDB {
+	case dbTypePostgres:
+		names := strings.Split(schema, ",")
+		for i, name := range names {
+			if names[i] = strings.TrimSpace(name); names[i] != "$user" {
+				names[i] = pq.QuoteIdentifier(names[i])
+			}
+		}
+
+		return []string{"SET search_path TO " + strings.Join(names, ", ")}
+	case dbTypeMySQL:
+		return []string{"USE " + quoteMySQL(schema)}
+	case dbTypeClickHouse:
+		return []string{"USE " + quoteClickHouse(schema)}
+	}
+
+	return nil
+}
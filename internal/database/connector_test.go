@@ -0,0 +1,25 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchemaSession(t *testing.T) {
+	tests := []struct {
+		typeDB, schema string
+		want           []string
+	}{
+		{dbTypePostgres, "", nil},
+		{dbTypePostgres, "public", []string{`SET search_path TO "public"`}},
+		{dbTypePostgres, "MyApp, $user, public", []string{`SET search_path TO "MyApp", $user, "public"`}},
+		{dbTypePostgres, `my-app"s`, []string{`SET search_path TO "my-app""s"`}},
+		{dbTypeMySQL, "app`db", []string{"USE `app``db`"}},
+	}
+
+	for _, tt := range tests {
+		if got := SchemaSession(tt.typeDB, tt.schema); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SchemaSession(%s, %q) = %q, want %q", tt.typeDB, tt.schema, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -11,7 +12,12 @@ import (
 	"github.com/librun/migrago/internal/config"
 )
 
-const dbTypePostgres = "postgres"
+// Supported database types.
+const (
+	dbTypePostgres   = "postgres"
+	dbTypeMySQL      = "mysql"
+	dbTypeClickHouse = "clickhouse"
+)
 
 // Errors.
 var (
@@ -25,8 +31,8 @@ type DB struct {
 	connect *sql.DB
 }
 
-// NewDB initializes connection to database.
-func NewDB(cfg *config.Database) (*DB, error) {
+// NewDB initializes connection to database. The context limits waiting for the database.
+func NewDB(ctx context.Context, cfg *config.Database) (*DB, error) {
 	db := DB{
 		typeDB: cfg.TypeDB,
 	}
@@ -35,15 +41,25 @@ func NewDB(cfg *config.Database) (*DB, error) {
 		return &db, ErrUnsupportedDB
 	}
 
-	connect, err := sql.Open(cfg.TypeDB, cfg.DSN)
+	session, err := sessionStatements(cfg)
+	if err != nil {
+		return &db, err
+	}
+
+	// The session is set up on every connection of the pool.
+	connect, err := Open(cfg.TypeDB, cfg.DSN, session)
 	if err != nil {
 		return &db, fmt.Errorf("connect: %w", err)
 	}
 
-	if cfg.TypeDB == dbTypePostgres && cfg.Schema != "" {
-		if _, err := connect.Exec("SET search_path TO " + cfg.Schema); err != nil {
-			return nil, fmt.Errorf("exec: %w", err)
+	configurePool(connect, cfg)
+
+	if err := waitConnect(ctx, connect, cfg); err != nil {
+		if errClose := connect.Close(); errClose != nil {
+			return &db, fmt.Errorf("close: %w", errClose)
 		}
+
+		return &db, fmt.Errorf("connect: %w", err)
 	}
 
 	db.connect = connect
@@ -51,16 +67,18 @@ func NewDB(cfg *config.Database) (*DB, error) {
 	return &db, nil
 }
 
-// Exec executes a query.
-func (db *DB) Exec(query string) error {
-	txn, err := db.connect.Begin()
+// Exec executes a query in a transaction. If the context is done, the running
+// statement is cancelled and the transaction is rolled back.
+func (db *DB) Exec(ctx context.Context, query string) error {
+	txn, err := db.connect.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin: %w", err)
 	}
 
-	if _, err := txn.Exec(query); err != nil {
-		if err := txn.Rollback(); err != nil {
-			return fmt.Errorf("rollback: %w", err)
+	if _, err := txn.ExecContext(ctx, query); err != nil {
+		// The transaction is already rolled back if the context is done.
+		if errRollback := txn.Rollback(); errRollback != nil && !errors.Is(errRollback, sql.ErrTxDone) {
+			return fmt.Errorf("rollback: %w", errRollback)
 		}
 
 		return fmt.Errorf("exec: %w", err)
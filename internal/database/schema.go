@@ -0,0 +1,646 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// schemaHeader is the first line of schema files.
+const schemaHeader = "-- Schema dumped by migrago, regenerate it instead of editing."
+
+// autoIncrementRe matches the counter of mysql tables, it is not a part of the schema.
+var autoIncrementRe = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// mysqlDefinerRe matches the definer of mysql routines and triggers, the user
+// running the dump may not exist in other environments.
+var mysqlDefinerRe = regexp.MustCompile("^CREATE DEFINER=`(?:[^`]|``)*`@`(?:[^`]|``)*` ")
+
+// DumpSchema returns DDL statements which create the selected schema of the
+// database. Statements and their order are stable, so dumps of equal schemas
+// are equal, and objects follow the objects they depend on, so the dump can
+// be executed as a migration. Tables and sequences named in exclude, such as
+// tables of the migrago storage, are skipped; a name is matched in any schema
+// unless it is qualified as "schema.name".
+func (db *DB) DumpSchema(ctx context.Context, exclude []string) ([]string, error) {
+	var (
+		statements []string
+		err        error
+	)
+
+	switch db.typeDB {
+	case dbTypePostgres:
+		statements, err = db.dumpPostgres(ctx, exclude)
+	case dbTypeMySQL:
+		statements, err = db.dumpMySQL(ctx, exclude)
+	case dbTypeClickHouse:
+		statements, err = db.dumpClickHouse(ctx, exclude)
+	default:
+		return nil, ErrUnsupportedDB
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("dump schema: %w", err)
+	}
+
+	return statements, nil
+}
+
+// FormatSchema returns the content of a schema file with the statements.
+func FormatSchema(statements []string) string {
+	return schemaHeader + "\n\n" + strings.Join(statements, "\n\n") + "\n"
+}
+
+// ParseSchema returns statements of a schema file. Statements are separated
+// by empty lines, comments between statements are skipped.
+func ParseSchema(content string) []string {
+	var statements []string
+
+	for _, chunk := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n\n") {
+		chunk = strings.TrimSpace(chunk)
+
+		lines := strings.Split(chunk, "\n")
+		for len(lines) > 0 && strings.HasPrefix(lines[0], "--") {
+			lines = lines[1:]
+		}
+
+		if statement := strings.TrimSpace(strings.Join(lines, "\n")); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements
+}
+
+// DiffSchema compares two dumps regardless of the statement order. It returns
+// statements of a missing in b and statements of b missing in a.
+func DiffSchema(a, b []string) (missing, unexpected []string) {
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+
+	sort.Strings(a)
+	sort.Strings(b)
+
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || i < len(a) && a[i] < b[j]:
+			missing = append(missing, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			unexpected = append(unexpected, b[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+
+	return missing, unexpected
+}
+
+func (db *DB) dumpPostgres(ctx context.Context, exclude []string) ([]string, error) {
+	const (
+		inSchema = `::text = ANY (current_schemas(false)::text[])`
+		relName  = `quote_ident(n.nspname) || '.' || quote_ident(c.relname)`
+	)
+
+	// notExcluded is a condition on the schema and the name of a relation, $1 is the exclude list.
+	notExcluded := func(schema, name string) string {
+		return ` AND NOT (` + name + ` = ANY ($1) OR ` + schema + ` || '.' || ` + name + ` = ANY ($1))`
+	}
+
+	// A nil array is NULL, which excludes everything.
+	excluded := pq.Array(append([]string{}, exclude...))
+
+	var statements []string
+
+	sequences, err := db.queryRows(ctx, `SELECT quote_ident(sequence_schema) || '.' || quote_ident(sequence_name), data_type
+		FROM information_schema.sequences
+		WHERE sequence_schema`+inSchema+notExcluded("sequence_schema", "sequence_name")+`
+		ORDER BY 1`, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range sequences {
+		statements = append(statements, "CREATE SEQUENCE "+row[0]+" AS "+row[1]+";")
+	}
+
+	// Functions are created before tables, which use them in defaults and checks,
+	// and their bodies are not checked, as bodies use tables. Functions with row
+	// types of tables or views in their signatures are created after views.
+	functions, err := db.queryRows(ctx, `SELECT pg_get_functiondef(p.oid), (EXISTS (
+			SELECT 1 FROM pg_depend d
+			JOIN pg_type t ON t.oid = d.refobjid
+			LEFT JOIN pg_type e ON e.oid = t.typelem
+			WHERE d.classid = 'pg_proc'::regclass AND d.objid = p.oid AND d.refclassid = 'pg_type'::regclass
+				AND (t.typrelid <> 0 OR e.typrelid <> 0)
+		))::text
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname`+inSchema+`
+			AND NOT EXISTS (SELECT 1 FROM pg_aggregate a WHERE a.aggfnoid = p.oid)
+			AND NOT EXISTS (
+				SELECT 1 FROM pg_depend d
+				WHERE d.classid = 'pg_proc'::regclass AND d.objid = p.oid AND d.deptype = 'e'
+			)
+		ORDER BY quote_ident(n.nspname) || '.' || quote_ident(p.proname), pg_get_function_identity_arguments(p.oid)`)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(functions) > 0 {
+		statements = append(statements, "SET LOCAL check_function_bodies = false;")
+	}
+
+	var rowTypeFunctions []string
+
+	for _, row := range functions {
+		function := strings.TrimSpace(row[0]) + ";"
+
+		if row[1] == "true" {
+			rowTypeFunctions = append(rowTypeFunctions, function)
+		} else {
+			statements = append(statements, function)
+		}
+	}
+
+	tables, err := db.queryRows(ctx, `SELECT `+relName+`
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND n.nspname`+inSchema+notExcluded("n.nspname", "c.relname")+`
+		ORDER BY 1`, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := db.queryRows(ctx, `SELECT `+relName+`, quote_ident(a.attname), format_type(a.atttypid, a.atttypmod),
+			CASE WHEN a.attnotnull THEN 'NOT NULL' ELSE '' END, COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped AND n.nspname`+inSchema+
+		notExcluded("n.nspname", "c.relname")+`
+		ORDER BY 1, a.attnum`, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	tableColumns := map[string][]string{}
+
+	for _, row := range columns {
+		column := "    " + row[1] + " " + row[2]
+		if row[4] != "" {
+			column += " DEFAULT " + row[4]
+		}
+
+		if row[3] != "" {
+			column += " " + row[3]
+		}
+
+		tableColumns[row[0]] = append(tableColumns[row[0]], column)
+	}
+
+	for _, row := range tables {
+		statements = append(statements, "CREATE TABLE "+row[0]+" (\n"+strings.Join(tableColumns[row[0]], ",\n")+"\n);")
+	}
+
+	constraints, err := db.queryRows(ctx, `SELECT `+relName+`, quote_ident(k.conname), pg_get_constraintdef(k.oid)
+		FROM pg_constraint k
+		JOIN pg_class c ON c.oid = k.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE k.contype IN ('p', 'u', 'f', 'c', 'x') AND n.nspname`+inSchema+notExcluded("n.nspname", "c.relname")+`
+		ORDER BY 1, 2`, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range constraints {
+		statements = append(statements, "ALTER TABLE "+row[0]+" ADD CONSTRAINT "+row[1]+" "+row[2]+";")
+	}
+
+	// Indexes of constraints are created by the constraints.
+	indexes, err := db.queryRows(ctx, `SELECT i.indexdef
+		FROM pg_indexes i
+		WHERE i.schemaname`+inSchema+notExcluded("i.schemaname", "i.tablename")+` AND NOT EXISTS (
+			SELECT 1 FROM pg_constraint k
+			WHERE k.conindid = (quote_ident(i.schemaname) || '.' || quote_ident(i.indexname))::regclass
+		)
+		ORDER BY i.schemaname, i.tablename, i.indexname`, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range indexes {
+		statements = append(statements, row[0]+";")
+	}
+
+	views, err := db.queryRows(ctx, `SELECT quote_ident(schemaname) || '.' || quote_ident(viewname), definition
+		FROM pg_views
+		WHERE schemaname`+inSchema+`
+		ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+
+	// A view is created after the views it selects from.
+	viewDeps, err := db.queryRows(ctx, `SELECT DISTINCT `+relName+`, quote_ident(dn.nspname) || '.' || quote_ident(d.relname)
+		FROM pg_rewrite r
+		JOIN pg_class c ON c.oid = r.ev_class
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_depend dep ON dep.classid = 'pg_rewrite'::regclass AND dep.objid = r.oid
+			AND dep.refclassid = 'pg_class'::regclass
+		JOIN pg_class d ON d.oid = dep.refobjid AND d.oid <> c.oid
+		JOIN pg_namespace dn ON dn.oid = d.relnamespace
+		WHERE c.relkind = 'v' AND d.relkind = 'v' AND n.nspname`+inSchema+`
+		ORDER BY 1, 2`)
+	if err != nil {
+		return nil, err
+	}
+
+	statements = append(statements, sortViews(views, viewDeps, func(name, definition string) string {
+		return "CREATE VIEW " + name + " AS\n" + viewDefinition(definition)
+	})...)
+
+	statements = append(statements, rowTypeFunctions...)
+
+	triggers, err := db.queryRows(ctx, `SELECT pg_get_triggerdef(t.oid)
+		FROM pg_trigger t
+		JOIN pg_class c ON c.oid = t.tgrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT t.tgisinternal AND n.nspname`+inSchema+notExcluded("n.nspname", "c.relname")+`
+		ORDER BY `+relName+`, t.tgname`, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range triggers {
+		statements = append(statements, row[0]+";")
+	}
+
+	return statements, nil
+}
+
+func (db *DB) dumpMySQL(ctx context.Context, exclude []string) ([]string, error) {
+	current, err := db.queryRows(ctx, "SELECT DATABASE()")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(current) != 1 || current[0][0] == "" {
+		return nil, errors.New("no database selected")
+	}
+
+	tables, err := db.queryRows(ctx, `SELECT table_name
+		FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'
+		ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements, foreignKeys []string
+
+	for _, row := range tables {
+		if isExcluded(exclude, row[0]) {
+			continue
+		}
+
+		create, err := db.showCreate(ctx, "TABLE", row[0], 1)
+		if err != nil {
+			return nil, err
+		}
+
+		// Foreign keys are added after all tables, so tables are created in any order.
+		table, keys := splitForeignKeys(quoteMySQL(row[0]), autoIncrementRe.ReplaceAllString(create, ""))
+		statements = append(statements, table+";")
+		foreignKeys = append(foreignKeys, keys...)
+	}
+
+	statements = append(statements, foreignKeys...)
+
+	// Bodies of routines are not checked when they are created, views use functions.
+	routines, err := db.queryRows(ctx, `SELECT routine_type, routine_name
+		FROM information_schema.routines
+		WHERE routine_schema = DATABASE()
+		ORDER BY routine_name, routine_type`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range routines {
+		create, err := db.showCreate(ctx, row[0], row[1], 2)
+		if err != nil {
+			return nil, err
+		}
+
+		statements = append(statements, mysqlDefinerRe.ReplaceAllString(create, "CREATE ")+";")
+	}
+
+	views, err := db.queryRows(ctx, `SELECT table_name, view_definition
+		FROM information_schema.views
+		WHERE table_schema = DATABASE()
+		ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Definitions qualify names by the database, a view is created after the views it selects from.
+	var viewDeps [][]string
+
+	for _, view := range views {
+		for _, dep := range views {
+			if dep[0] != view[0] && strings.Contains(view[1], quoteMySQL(current[0][0])+"."+quoteMySQL(dep[0])) {
+				viewDeps = append(viewDeps, []string{view[0], dep[0]})
+			}
+		}
+	}
+
+	statements = append(statements, sortViews(views, viewDeps, func(name, definition string) string {
+		return "CREATE VIEW " + quoteMySQL(name) + " AS\n" + viewDefinition(definition)
+	})...)
+
+	triggers, err := db.queryRows(ctx, `SELECT trigger_name, event_object_table
+		FROM information_schema.triggers
+		WHERE trigger_schema = DATABASE()
+		ORDER BY event_object_table, action_timing, event_manipulation, action_order`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range triggers {
+		if isExcluded(exclude, row[1]) {
+			continue
+		}
+
+		create, err := db.showCreate(ctx, "TRIGGER", row[0], 2)
+		if err != nil {
+			return nil, err
+		}
+
+		statements = append(statements, mysqlDefinerRe.ReplaceAllString(create, "CREATE ")+";")
+	}
+
+	return statements, nil
+}
+
+// showCreate returns the column of the SHOW CREATE statement of the mysql object.
+func (db *DB) showCreate(ctx context.Context, kind, name string, column int) (string, error) {
+	rows, err := db.queryRows(ctx, "SHOW CREATE "+kind+" "+quoteMySQL(name))
+	if err != nil {
+		return "", err
+	}
+
+	// Definitions of routines are NULL without privileges on them.
+	if len(rows) != 1 || len(rows[0]) <= column || rows[0][column] == "" {
+		return "", fmt.Errorf("show create %s %s: no definition", strings.ToLower(kind), name)
+	}
+
+	return rows[0][column], nil
+}
+
+func (db *DB) dumpClickHouse(ctx context.Context, exclude []string) ([]string, error) {
+	current, err := db.queryRows(ctx, "SELECT currentDatabase()")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(current) != 1 {
+		return nil, errors.New("no database selected")
+	}
+
+	// Inner tables of materialized views are created by the views.
+	tables, err := db.queryRows(ctx, `SELECT name, create_table_query, engine
+		FROM system.tables
+		WHERE database = currentDatabase() AND NOT is_temporary AND name NOT LIKE '.inner%'
+		ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []string
+
+	// Views are created after tables, and after the views they select from.
+	var views, viewDeps [][]string
+
+	for _, row := range tables {
+		switch {
+		case isExcluded(exclude, row[0]):
+		case row[2] == "View" || row[2] == "MaterializedView":
+			views = append(views, row)
+		default:
+			statements = append(statements, row[1]+";")
+		}
+	}
+
+	for _, view := range views {
+		for _, dep := range views {
+			if dep[0] != view[0] && referencesClickHouse(view[1], current[0][0], dep[0]) {
+				viewDeps = append(viewDeps, []string{view[0], dep[0]})
+			}
+		}
+	}
+
+	statements = append(statements, sortViews(views, viewDeps, func(_, query string) string {
+		return query + ";"
+	})...)
+
+	return statements, nil
+}
+
+// sortViews returns statements of the views, rows of the name and the
+// definition, so that a view follows the views it depends on. Dependencies
+// are rows of the name of a view and the name of a view it uses.
+func sortViews(views, deps [][]string, statement func(name, definition string) string) []string {
+	names := make([]string, 0, len(views))
+	definitions := make(map[string]string, len(views))
+
+	for _, row := range views {
+		names = append(names, row[0])
+		definitions[row[0]] = row[1]
+	}
+
+	viewDeps := map[string][]string{}
+	for _, row := range deps {
+		viewDeps[row[0]] = append(viewDeps[row[0]], row[1])
+	}
+
+	statements := make([]string, 0, len(views))
+	for _, name := range sortByDependency(names, viewDeps) {
+		statements = append(statements, statement(name, definitions[name]))
+	}
+
+	return statements
+}
+
+// sortByDependency returns the names ordered so that every name follows the
+// names it depends on, otherwise the order is kept. Dependencies which are not
+// among the names are ignored, a cycle is broken at the first name of it.
+func sortByDependency(names []string, deps map[string][]string) []string {
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	visited := make(map[string]bool, len(names))
+
+	var visit func(name string)
+
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+
+		visited[name] = true
+
+		for _, dep := range deps[name] {
+			if known[dep] {
+				visit(dep)
+			}
+		}
+
+		sorted = append(sorted, name)
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+
+	return sorted
+}
+
+// splitForeignKeys removes foreign keys from the mysql CREATE TABLE statement
+// and returns them as ALTER TABLE statements.
+func splitForeignKeys(table, create string) (string, []string) {
+	lines := strings.Split(create, "\n")
+	if len(lines) < 3 {
+		return create, nil
+	}
+
+	var (
+		columns []string
+		keys    []string
+	)
+
+	// Definitions are on their own lines between the first and the last line.
+	for _, line := range lines[1 : len(lines)-1] {
+		definition := strings.TrimSuffix(line, ",")
+
+		if trimmed := strings.TrimSpace(definition); strings.HasPrefix(trimmed, "CONSTRAINT ") &&
+			strings.Contains(trimmed, " FOREIGN KEY ") {
+			keys = append(keys, "ALTER TABLE "+table+" ADD "+trimmed+";")
+		} else {
+			columns = append(columns, definition)
+		}
+	}
+
+	if len(keys) == 0 {
+		return create, nil
+	}
+
+	return lines[0] + "\n" + strings.Join(columns, ",\n") + "\n" + lines[len(lines)-1], keys
+}
+
+// referencesClickHouse reports whether the clickhouse query uses the table of
+// the database. Queries qualify names by the database and quote names which
+// are not plain identifiers.
+func referencesClickHouse(query, database, name string) bool {
+	for _, db := range []string{database, quoteClickHouse(database)} {
+		for _, table := range []string{name, quoteClickHouse(name)} {
+			ref := db + "." + table
+
+			for i := strings.Index(query, ref); i >= 0; {
+				end := i + len(ref)
+				if (i == 0 || !isIdentByte(query[i-1])) && (end == len(query) || !isIdentByte(query[end])) {
+					return true
+				}
+
+				next := strings.Index(query[i+1:], ref)
+				if next < 0 {
+					break
+				}
+
+				i += next + 1
+			}
+		}
+	}
+
+	return false
+}
+
+// isIdentByte reports whether the byte can be a part of an unquoted identifier or qualified name.
+func isIdentByte(b byte) bool {
+	return b == '_' || b == '.' || b == '`' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// isExcluded reports whether the table of the current database is in the exclude list.
+func isExcluded(exclude []string, name string) bool {
+	for _, e := range exclude {
+		if e == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// viewDefinition returns the query of a view as a statement.
+func viewDefinition(definition string) string {
+	return strings.TrimSuffix(strings.TrimSpace(definition), ";") + ";"
+}
+
+func quoteMySQL(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteClickHouse(name string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(name) + "`"
+}
+
+// queryRows returns rows of the query as strings, NULL is an empty string.
+func (db *DB) queryRows(ctx context.Context, query string, args ...interface{}) ([][]string, error) {
+	rows, err := db.connect.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var result [][]string
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(values))
+		for i, v := range values {
+			row[i] = v.String
+		}
+
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/librun/migrago/internal/config"
+)
+
+func TestDiffSchema(t *testing.T) {
+	a := []string{"CREATE TABLE b (\n    id integer\n);", "CREATE TABLE a (\n    id integer\n);", "CREATE INDEX i ON a (id);"}
+	b := []string{"CREATE TABLE a (\n    id integer\n);", "CREATE TABLE b (\n    id bigint\n);", "CREATE TABLE c (\n    id integer\n);"}
+
+	missing, unexpected := DiffSchema(a, b)
+
+	wantMissing := []string{"CREATE INDEX i ON a (id);", "CREATE TABLE b (\n    id integer\n);"}
+	if !reflect.DeepEqual(missing, wantMissing) {
+		t.Errorf("missing = %q, want %q", missing, wantMissing)
+	}
+
+	wantUnexpected := []string{"CREATE TABLE b (\n    id bigint\n);", "CREATE TABLE c (\n    id integer\n);"}
+	if !reflect.DeepEqual(unexpected, wantUnexpected) {
+		t.Errorf("unexpected = %q, want %q", unexpected, wantUnexpected)
+	}
+
+	if a[0] != "CREATE TABLE b (\n    id integer\n);" {
+		t.Error("dump is sorted in place")
+	}
+
+	if missing, unexpected := DiffSchema(a, []string{a[2], a[1], a[0]}); len(missing)+len(unexpected) > 0 {
+		t.Errorf("equal dumps differ: %q %q", missing, unexpected)
+	}
+}
+
+func TestSortByDependency(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+	deps := map[string][]string{
+		"a": {"c"},
+		"c": {"d", "x"},
+		// A cycle is broken, every name is returned once.
+		"d": {"c"},
+	}
+
+	want := []string{"d", "c", "a", "b"}
+	if got := sortByDependency(names, deps); !reflect.DeepEqual(got, want) {
+		t.Errorf("sortByDependency() = %q, want %q", got, want)
+	}
+}
+
+func TestSplitForeignKeys(t *testing.T) {
+	create := "CREATE TABLE `orders` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  `user_id` int DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `user_id` (`user_id`),\n" +
+		"  CONSTRAINT `orders_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+	table, keys := splitForeignKeys("`orders`", create)
+
+	wantTable := "CREATE TABLE `orders` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  `user_id` int DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `user_id` (`user_id`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	if table != wantTable {
+		t.Errorf("table = %q, want %q", table, wantTable)
+	}
+
+	wantKeys := []string{"ALTER TABLE `orders` ADD CONSTRAINT `orders_ibfk_1` FOREIGN KEY (`user_id`) " +
+		"REFERENCES `users` (`id`) ON DELETE CASCADE;"}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("keys = %q, want %q", keys, wantKeys)
+	}
+
+	users := "CREATE TABLE `users` (\n  `id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB"
+	if table, keys := splitForeignKeys("`users`", users); table != users || keys != nil {
+		t.Errorf("table without foreign keys changed: %q %q", table, keys)
+	}
+}
+
+func TestReferencesClickHouse(t *testing.T) {
+	tests := []struct {
+		query, name string
+		want        bool
+	}{
+		{"CREATE VIEW shop.totals AS SELECT * FROM shop.orders", "orders", true},
+		{"CREATE VIEW shop.totals AS SELECT * FROM shop.orders_archive", "orders", false},
+		{"CREATE VIEW shop.totals AS SELECT * FROM other.orders", "orders", false},
+		{"CREATE VIEW shop.totals AS SELECT * FROM shop.`order-items`", "order-items", true},
+		{"CREATE MATERIALIZED VIEW shop.mv TO shop.daily AS SELECT * FROM shop.orders", "daily", true},
+	}
+
+	for _, tt := range tests {
+		if got := referencesClickHouse(tt.query, "shop", tt.name); got != tt.want {
+			t.Errorf("referencesClickHouse(%q, %q) = %v, want %v", tt.query, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMySQLDefiner(t *testing.T) {
+	create := "CREATE DEFINER=`root`@`%` TRIGGER `orders_touch` BEFORE UPDATE ON `orders` FOR EACH ROW SET NEW.`n` = 1"
+	want := "CREATE TRIGGER `orders_touch` BEFORE UPDATE ON `orders` FOR EACH ROW SET NEW.`n` = 1"
+
+	if got := mysqlDefinerRe.ReplaceAllString(create, "CREATE "); got != want {
+		t.Errorf("definer is not removed: %q", got)
+	}
+}
+
+// dumpTestSchema is a schema or a database the baseline tests create and drop.
+const dumpTestSchema = "migrago_dump_test"
+
+// TestDumpSchemaBaselinePostgres needs a postgres database in MIGRAGO_TEST_POSTGRES_DSN.
+func TestDumpSchemaBaselinePostgres(t *testing.T) {
+	// Names are ordered against dependencies: a_orders uses b_users, a_short_names uses b_names.
+	testDumpBaseline(t, dbTypePostgres, "MIGRAGO_TEST_POSTGRES_DSN",
+		"DROP SCHEMA IF EXISTS "+dumpTestSchema+" CASCADE; CREATE SCHEMA "+dumpTestSchema, `
+		CREATE TABLE b_users (id serial PRIMARY KEY, name text NOT NULL);
+		CREATE FUNCTION next_code() RETURNS text LANGUAGE sql AS $$ SELECT 'c' || count(*) FROM b_users $$;
+		CREATE TABLE a_orders (id serial PRIMARY KEY, user_id integer REFERENCES b_users (id), code text DEFAULT next_code());
+		CREATE FUNCTION active_users() RETURNS SETOF b_users LANGUAGE sql AS $$ SELECT * FROM b_users $$;
+		CREATE VIEW b_names AS SELECT id, name FROM b_users;
+		CREATE VIEW a_short_names AS SELECT id FROM b_names WHERE length(name) < 5;
+		CREATE FUNCTION touch() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			NEW.code := upper(NEW.code);
+
+			RETURN NEW;
+		END
+		$$;
+		CREATE TRIGGER a_orders_touch BEFORE INSERT ON a_orders FOR EACH ROW EXECUTE PROCEDURE touch();`)
+}
+
+// TestDumpSchemaBaselineMySQL needs a mysql server in MIGRAGO_TEST_MYSQL_DSN with multiStatements=true.
+func TestDumpSchemaBaselineMySQL(t *testing.T) {
+	testDumpBaseline(t, dbTypeMySQL, "MIGRAGO_TEST_MYSQL_DSN",
+		"DROP DATABASE IF EXISTS "+dumpTestSchema+"; CREATE DATABASE "+dumpTestSchema, `
+		CREATE TABLE b_users (id int NOT NULL PRIMARY KEY, name varchar(50) NOT NULL);
+		CREATE TABLE a_orders (id int NOT NULL PRIMARY KEY, user_id int, code varchar(20),
+			CONSTRAINT a_orders_user FOREIGN KEY (user_id) REFERENCES b_users (id));
+		CREATE FUNCTION short_name(name varchar(50)) RETURNS varchar(5) DETERMINISTIC RETURN left(name, 5);
+		CREATE VIEW b_names AS SELECT id, short_name(name) AS name FROM b_users;
+		CREATE VIEW a_short_names AS SELECT id FROM b_names WHERE name = 'x';
+		CREATE TRIGGER a_orders_code BEFORE INSERT ON a_orders FOR EACH ROW SET NEW.code = upper(NEW.code);`)
+}
+
+// testDumpBaseline creates the fixture in the test schema, recreates the schema
+// from its dump as squash --from-schema does and compares the dumps.
+func testDumpBaseline(t *testing.T, typeDB, env, reset, fixture string) {
+	t.Helper()
+
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		t.Skip(env + " is not set")
+	}
+
+	ctx := context.Background()
+
+	// A dropped database is not selected again, so connections are opened after every reset.
+	exec := func(query string) ([]string, error) {
+		admin, err := NewDB(ctx, &config.Database{TypeDB: typeDB, DSN: dsn})
+		if err != nil {
+			return nil, err
+		}
+		defer admin.Close()
+
+		if err := admin.Exec(ctx, reset); err != nil {
+			return nil, err
+		}
+
+		dbc, err := NewDB(ctx, &config.Database{TypeDB: typeDB, DSN: dsn, Schema: dumpTestSchema})
+		if err != nil {
+			return nil, err
+		}
+		defer dbc.Close()
+
+		if err := dbc.Exec(ctx, query); err != nil {
+			return nil, err
+		}
+
+		return dbc.DumpSchema(ctx, nil)
+	}
+
+	dump, err := exec(fixture)
+	if err != nil {
+		t.Fatalf("fixture: %v", err)
+	}
+
+	restored, err := exec(strings.Join(dump, "\n\n"))
+	if err != nil {
+		t.Fatalf("baseline: %v\n%s", err, strings.Join(dump, "\n\n"))
+	}
+
+	if missing, unexpected := DiffSchema(dump, restored); len(missing)+len(unexpected) > 0 {
+		t.Errorf("dump of the baseline differs:\nmissing: %q\nunexpected: %q", missing, unexpected)
+	}
+}
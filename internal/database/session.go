@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/librun/migrago/internal/config"
+)
+
+// connectRetryDelayDefault is a default delay between connection attempts.
+const connectRetryDelayDefault = time.Second
+
+// settingNameRe matches names of session settings, postgres custom settings contain a dot.
+var settingNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// sessionStatements returns statements run on every connection of the database:
+// the schema selection, the settings and the statements of the session block.
+func sessionStatements(cfg *config.Database) ([]string, error) {
+	statements := SchemaSession(cfg.TypeDB, cfg.Schema)
+
+	names := make([]string, 0, len(cfg.Session.Settings))
+	for name := range cfg.Session.Settings {
+		names = append(names, name)
+	}
+
+	// Settings are applied in a stable order.
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !settingNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid session setting name %q", name)
+		}
+
+		value := quoteSetting(cfg.TypeDB, cfg.Session.Settings[name])
+
+		if cfg.TypeDB == dbTypeMySQL {
+			statements = append(statements, "SET SESSION "+name+" = "+value)
+		} else {
+			statements = append(statements, "SET "+name+" = "+value)
+		}
+	}
+
+	return append(statements, cfg.Session.Statements...), nil
+}
+
+// quoteSetting returns a numeric value as is and quotes other values as string literals.
+func quoteSetting(typeDB, value string) string {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+
+	switch typeDB {
+	case dbTypeMySQL, dbTypeClickHouse:
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+	default:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+}
+
+// configurePool applies connection pool limits of the database.
+func configurePool(connect *sql.DB, cfg *config.Database) {
+	if cfg.MaxOpenConns > 0 {
+		connect.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+
+	if cfg.MaxIdleConns > 0 {
+		connect.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+
+	if cfg.ConnMaxLifetime > 0 {
+		connect.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+}
+
+// waitConnect checks the connection if a connect timeout or retries are configured.
+// Failed attempts are retried, so a database which is still starting can be waited for.
+func waitConnect(ctx context.Context, connect *sql.DB, cfg *config.Database) error {
+	if cfg.ConnectTimeout <= 0 && cfg.ConnectRetries <= 0 {
+		return nil
+	}
+
+	delay := cfg.ConnectRetryDelay
+	if delay <= 0 {
+		delay = connectRetryDelayDefault
+	}
+
+	var err error
+
+	for attempt := 0; attempt <= cfg.ConnectRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("database %s: connect attempt %d of %d failed: %v, retry in %s",
+				cfg.Name, attempt, cfg.ConnectRetries+1, err, delay)
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return fmt.Errorf("ping: %v: %w", err, ctx.Err())
+			}
+		}
+
+		if err = ping(ctx, connect, cfg.ConnectTimeout); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("ping: %w", err)
+}
+
+func ping(ctx context.Context, connect *sql.DB, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+
+		defer cancel()
+	}
+
+	return connect.PingContext(ctx)
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/librun/migrago/internal/config"
+)
+
+// ListTenants returns tenant names from the list, the file and the query of
+// the tenant sources. Names are returned once in the order of the sources.
+func ListTenants(ctx context.Context, tenants *config.Tenants) ([]string, error) {
+	names := append([]string{}, tenants.List...)
+
+	if tenants.File != "" {
+		fileNames, err := readTenants(tenants.File)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, fileNames...)
+	}
+
+	if tenants.Query != "" {
+		queryNames, err := queryTenants(ctx, tenants.Catalog, tenants.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, queryNames...)
+	}
+
+	seen := make(map[string]bool, len(names))
+	result := make([]string, 0, len(names))
+
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+
+	return result, nil
+}
+
+// readTenants reads tenant names from the file, one per line. Empty lines and
+// lines starting with # are skipped.
+func readTenants(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read tenants: %w", err)
+	}
+
+	var names []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		names = append(names, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read tenants: %w", err)
+	}
+
+	return names, nil
+}
+
+// queryTenants returns the first column of the query result in the catalog database.
+func queryTenants(ctx context.Context, catalog *config.Database, query string) ([]string, error) {
+	db, err := NewDB(ctx, catalog)
+	if err != nil {
+		return nil, fmt.Errorf("connect to catalog %s: %w", catalog.Name, err)
+	}
+	defer db.Close()
+
+	rows, err := db.connect.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query tenants: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("query tenants: %w", err)
+		}
+
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query tenants: %w", err)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,47 @@
+package migration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/librun/migrago/internal/config"
+)
+
+// writeMigrations creates migration files in a temporary directory.
+// The directory is removed by the returned function.
+func writeMigrations(t *testing.T, files map[string]string) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "migrago-migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// scanMigrations returns migrations of the directory with the default naming.
+func scanMigrations(t *testing.T, dir string) []File {
+	t.Helper()
+
+	scheme, err := NewScheme(config.Naming{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Scan([]string{dir}, scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
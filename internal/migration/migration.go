@@ -0,0 +1,232 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// postfixUp is a postfix for file up migrate.
+	postfixUp = "_up.sql"
+
+	// postfixDown is a postfix for file down migrate.
+	postfixDown = "_down.sql"
+
+	// postfixRepeat is a postfix for file of repeatable migration.
+	postfixRepeat = "_repeat.sql"
+)
+
+// File describes a migration found in the migration directories.
+type File struct {
+	Version  string
+	UpPath   string
+	DownPath string // empty if the migration has no down file (or empty down section)
+	// Single is true for a file containing both directions separated by markers.
+	Single bool
+}
+
+// Scan finds migrations in the directories and returns them sorted by version.
+// The same version in two files is an error.
+func Scan(dirs []string, scheme *Scheme) ([]File, error) {
+	files, err := ScanAll(dirs, scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(files); i++ {
+		if files[i].Version == files[i-1].Version {
+			return nil, fmt.Errorf("migration %s found in directories %s and %s", files[i].Version,
+				filepath.Dir(files[i-1].UpPath), filepath.Dir(files[i].UpPath))
+		}
+	}
+
+	return files, nil
+}
+
+// ScanAll finds migrations in the directories and returns them sorted by
+// version. Unlike Scan it allows duplicate versions.
+func ScanAll(dirs []string, scheme *Scheme) ([]File, error) {
+	files := make([]File, 0)
+
+	for _, dir := range dirs {
+		filesInDir, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("get files list: %w", err)
+		}
+
+		downFiles := map[string]string{}
+
+		for _, f := range filesInDir {
+			if f.IsDir() {
+				continue
+			}
+
+			if version, ok := scheme.parseDown(f.Name()); ok {
+				downFiles[version] = filepath.Join(dir, f.Name())
+			}
+		}
+
+		for _, f := range filesInDir {
+			if f.IsDir() {
+				continue
+			}
+
+			file, ok, err := scanFile(scheme, dir, f.Name(), downFiles)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				files = append(files, file)
+			}
+		}
+	}
+
+	// Sort the list of migrations by version.
+	sort.SliceStable(files, func(i, j int) bool {
+		return scheme.Less(files[i].Version, files[j].Version)
+	})
+
+	return files, nil
+}
+
+// scanFile returns a migration for an up file or a single file with sections.
+func scanFile(scheme *Scheme, dir, fileName string, downFiles map[string]string) (File, bool, error) {
+	path := filepath.Join(dir, fileName)
+
+	// Repeatable migrations have no versions.
+	if _, ok := scheme.parseRepeat(fileName); ok {
+		return File{}, false, nil
+	}
+
+	if version, ok := scheme.parseUp(fileName); ok {
+		return File{Version: version, UpPath: path, DownPath: downFiles[version]}, true, nil
+	}
+
+	// Down files are found by the up file.
+	if _, ok := scheme.parseDown(fileName); ok {
+		return File{}, false, nil
+	}
+
+	version, ok := scheme.parseSingle(fileName)
+	if !ok {
+		return File{}, false, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return File{}, false, fmt.Errorf("read file: %w", err)
+	}
+
+	// Files without markers are not migrations.
+	_, down, ok := parseSections(string(content))
+	if !ok {
+		return File{}, false, nil
+	}
+
+	file := File{Version: version, UpPath: path, Single: true}
+	if strings.TrimSpace(down) != "" {
+		file.DownPath = file.UpPath
+	}
+
+	return file, true, nil
+}
+
+// ReadUp returns query of the up migration.
+func (f *File) ReadUp() (string, error) {
+	up, _, err := f.read(f.UpPath)
+
+	return up, err
+}
+
+// ReadDown returns query of the down migration.
+func (f *File) ReadDown() (string, error) {
+	if f.Single {
+		_, down, err := f.read(f.DownPath)
+
+		return down, err
+	}
+
+	down, _, err := f.read(f.DownPath)
+
+	return down, err
+}
+
+// read returns content of the file. For a single file it returns up and down sections.
+func (f *File) read(path string) (string, string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("read file: %w", err)
+	}
+
+	if !f.Single {
+		return string(content), "", nil
+	}
+
+	up, down, ok := parseSections(string(content))
+	if !ok {
+		return "", "", fmt.Errorf("file %s has no up marker", path)
+	}
+
+	return up, down, nil
+}
+
+// Checksum returns SHA-256 of the migration query.
+func Checksum(query string) string {
+	sum := sha256.Sum256([]byte(query))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Find returns migration by version.
+func Find(files []File, version string) (File, bool) {
+	for _, f := range files {
+		if f.Version == version {
+			return f, true
+		}
+	}
+
+	return File{}, false
+}
+
+// IsEmpty reports whether the query has no statements, only whitespace and
+// comments. Drivers fail on empty queries, so such files are not executed.
+func IsEmpty(query string) bool {
+	for {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+
+		switch {
+		case query == "":
+			return true
+		case strings.HasPrefix(query, "--"), strings.HasPrefix(query, "#"):
+			// A line comment, # is a line comment in MySQL.
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return true
+			}
+
+			query = query[end+1:]
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query[2:], "*/")
+			if end < 0 {
+				// An unterminated comment is left to the database to report.
+				return false
+			}
+
+			query = query[end+4:]
+		default:
+			return false
+		}
+	}
+}
+
+// Rollback reports whether the migration has a down file.
+func (f *File) Rollback() bool {
+	return f.DownPath != ""
+}
@@ -0,0 +1,26 @@
+package migration
+
+import "testing"
+
+func TestIsEmpty(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"", true},
+		{" \n\t", true},
+		{"-- Migration: name\n-- Note: comment\n\n", true},
+		{"-- no line break", true},
+		{"# mysql comment\n/* block\ncomment */\n", true},
+		{"-- comment\nSELECT 1;", false},
+		{"/* comment */ SELECT 1;", false},
+		{"/* unterminated", false},
+		{"SET LOCAL lock_timeout = '5s';", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsEmpty(tt.query); got != tt.want {
+			t.Errorf("IsEmpty(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
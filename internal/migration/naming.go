@@ -0,0 +1,397 @@
+package migration
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/librun/migrago/internal/config"
+)
+
+// Naming presets.
+const (
+	PresetMigrago       = "migrago"
+	PresetGolangMigrate = "golang-migrate"
+	PresetFlyway        = "flyway"
+	PresetGoose         = "goose"
+)
+
+// Version comparison rules.
+const (
+	CompareLexicographic = "lexicographic"
+	CompareNumeric       = "numeric"
+	CompareSemver        = "semver"
+)
+
+// Placeholders for file name templates.
+const (
+	placeholderVersion = "{version}"
+	placeholderName    = "{name}"
+)
+
+// presets contains naming of well-known migration tools.
+var presets = map[string]config.Naming{
+	// The version is the whole file name without postfix: 20200101_150405_name.
+	// Single files with sections: 20200101_150405_name.sql. Repeatable: name_repeat.sql.
+	PresetMigrago: {
+		Up:            `^(?P<version>.+)` + regexp.QuoteMeta(postfixUp) + `$`,
+		Down:          `^(?P<version>.+)` + regexp.QuoteMeta(postfixDown) + `$`,
+		Single:        `^(?P<version>.+)\.sql$`,
+		Repeat:        `^(?P<version>.+)` + regexp.QuoteMeta(postfixRepeat) + `$`,
+		Compare:       CompareLexicographic,
+		CreateUp:      placeholderVersion + "_" + placeholderName + postfixUp,
+		CreateDown:    placeholderVersion + "_" + placeholderName + postfixDown,
+		CreateSingle:  placeholderVersion + "_" + placeholderName + ".sql",
+		VersionFormat: "20060102_150405",
+	},
+	// 1_name.up.sql, 20200101150405_name.down.sql.
+	PresetGolangMigrate: {
+		Up:            `^(?P<version>\d+)_(?P<name>.*)\.up\.sql$`,
+		Down:          `^(?P<version>\d+)_(?P<name>.*)\.down\.sql$`,
+		Compare:       CompareNumeric,
+		CreateUp:      placeholderVersion + "_" + placeholderName + ".up.sql",
+		CreateDown:    placeholderVersion + "_" + placeholderName + ".down.sql",
+		VersionFormat: "20060102150405",
+	},
+	// V1.2__name.sql for migrations, U1.2__name.sql for undo migrations,
+	// R__name.sql for repeatable migrations.
+	PresetFlyway: {
+		Up:            `^V(?P<version>[0-9][0-9._]*)__(?P<name>.+)\.sql$`,
+		Down:          `^U(?P<version>[0-9][0-9._]*)__(?P<name>.+)\.sql$`,
+		Repeat:        `^R__(?P<version>.+)\.sql$`,
+		Compare:       CompareSemver,
+		CreateUp:      "V" + placeholderVersion + "__" + placeholderName + ".sql",
+		CreateDown:    "U" + placeholderVersion + "__" + placeholderName + ".sql",
+		VersionFormat: "20060102150405",
+	},
+	// Single files only: 20200101150405_name.sql with "-- +goose Up" and "-- +goose Down" sections.
+	PresetGoose: {
+		Single:        `^(?P<version>\d+)_(?P<name>.+)\.sql$`,
+		Compare:       CompareNumeric,
+		CreateSingle:  placeholderVersion + "_" + placeholderName + ".sql",
+		VersionFormat: "20060102150405",
+	},
+}
+
+// Scheme describes how migration files are named and how versions are ordered.
+type Scheme struct {
+	up            *regexp.Regexp
+	down          *regexp.Regexp
+	single        *regexp.Regexp
+	repeat        *regexp.Regexp
+	compare       func(a, b string) int
+	createUp      string
+	createDown    string
+	createSingle  string
+	versionFormat string
+	sequence      bool
+	seqDigits     int
+}
+
+// NewScheme builds naming scheme from the project config. Empty fields are
+// taken from the preset (migrago by default).
+func NewScheme(naming config.Naming) (*Scheme, error) {
+	if naming.Preset == "" {
+		naming.Preset = PresetMigrago
+	}
+
+	preset, ok := presets[naming.Preset]
+	if !ok {
+		return nil, fmt.Errorf("naming: unknown preset %s", naming.Preset)
+	}
+
+	naming = mergeNaming(naming, preset)
+
+	s := Scheme{
+		createUp:      naming.CreateUp,
+		createDown:    naming.CreateDown,
+		createSingle:  naming.CreateSingle,
+		versionFormat: naming.VersionFormat,
+		sequence:      naming.Sequence,
+		seqDigits:     naming.SequenceDigits,
+	}
+
+	if s.seqDigits <= 0 {
+		s.seqDigits = sequenceDigitsDefault
+	}
+
+	var err error
+
+	if s.up, err = compileVersionRegexp(naming.Up); err != nil {
+		return nil, fmt.Errorf("naming up: %w", err)
+	}
+
+	if s.down, err = compileVersionRegexp(naming.Down); err != nil {
+		return nil, fmt.Errorf("naming down: %w", err)
+	}
+
+	if s.single, err = compileVersionRegexp(naming.Single); err != nil {
+		return nil, fmt.Errorf("naming single: %w", err)
+	}
+
+	if s.repeat, err = compileVersionRegexp(naming.Repeat); err != nil {
+		return nil, fmt.Errorf("naming repeat: %w", err)
+	}
+
+	if s.up == nil && s.single == nil {
+		return nil, fmt.Errorf("naming: neither up nor single pattern is set")
+	}
+
+	switch naming.Compare {
+	case CompareLexicographic:
+		s.compare = strings.Compare
+	case CompareNumeric:
+		s.compare = compareNumeric
+	case CompareSemver:
+		s.compare = compareSemver
+	default:
+		return nil, fmt.Errorf("naming: unknown compare rule %s", naming.Compare)
+	}
+
+	return &s, nil
+}
+
+// Less reports whether version a must be applied before version b.
+func (s *Scheme) Less(a, b string) bool {
+	return s.compare(a, b) < 0
+}
+
+// Compare returns an integer comparing two versions.
+func (s *Scheme) Compare(a, b string) int {
+	return s.compare(a, b)
+}
+
+// NewVersion returns a version for a new migration created at t. For sequence
+// versions it returns the number following the largest one among files.
+func (s *Scheme) NewVersion(t time.Time, files []File) string {
+	if s.sequence {
+		return s.formatSequence(nextSequence(files))
+	}
+
+	return t.Format(s.versionFormat)
+}
+
+// Sequence reports whether new migrations use sequence versions.
+func (s *Scheme) Sequence() bool {
+	return s.sequence
+}
+
+// FileName returns the name of a new migration file.
+func (s *Scheme) FileName(version, name string, down bool) string {
+	tpl := s.createUp
+	if down {
+		tpl = s.createDown
+	}
+
+	return fileName(tpl, version, name)
+}
+
+// SingleFileName returns the name of a new migration file with both sections.
+func (s *Scheme) SingleFileName(version, name string) string {
+	return fileName(s.createSingle, version, name)
+}
+
+// SupportSeparate reports whether the scheme allows separate up and down files.
+func (s *Scheme) SupportSeparate() bool {
+	return s.up != nil && s.createUp != ""
+}
+
+// SupportSingle reports whether the scheme allows single files with sections.
+func (s *Scheme) SupportSingle() bool {
+	return s.single != nil && s.createSingle != ""
+}
+
+func fileName(tpl, version, name string) string {
+	return strings.NewReplacer(placeholderVersion, version, placeholderName, name).Replace(tpl)
+}
+
+// parseUp returns the version of an up migration file.
+func (s *Scheme) parseUp(fileName string) (string, bool) {
+	return matchVersion(s.up, fileName)
+}
+
+// parseDown returns the version of a down migration file.
+func (s *Scheme) parseDown(fileName string) (string, bool) {
+	return matchVersion(s.down, fileName)
+}
+
+// parseSingle returns the version of a file with both sections.
+func (s *Scheme) parseSingle(fileName string) (string, bool) {
+	return matchVersion(s.single, fileName)
+}
+
+// parseRepeat returns the name of a repeatable migration file.
+func (s *Scheme) parseRepeat(fileName string) (string, bool) {
+	return matchVersion(s.repeat, fileName)
+}
+
+func matchVersion(re *regexp.Regexp, fileName string) (string, bool) {
+	if re == nil {
+		return "", false
+	}
+
+	m := re.FindStringSubmatch(fileName)
+	if m == nil {
+		return "", false
+	}
+
+	version := m[versionIndex(re)]
+
+	return version, version != ""
+}
+
+// compileVersionRegexp compiles a file name pattern. An empty pattern disables the file kind.
+func compileVersionRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if versionIndex(re) < 0 {
+		return nil, fmt.Errorf("pattern %s has no (?P<version>...) group", expr)
+	}
+
+	return re, nil
+}
+
+// versionIndex returns index of the "version" group or -1.
+func versionIndex(re *regexp.Regexp) int {
+	for i, name := range re.SubexpNames() {
+		if name == "version" {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func mergeNaming(naming, preset config.Naming) config.Naming {
+	if naming.Up == "" {
+		naming.Up = preset.Up
+	}
+
+	if naming.Down == "" {
+		naming.Down = preset.Down
+	}
+
+	if naming.Single == "" {
+		naming.Single = preset.Single
+	}
+
+	if naming.Repeat == "" {
+		naming.Repeat = preset.Repeat
+	}
+
+	if naming.Compare == "" {
+		naming.Compare = preset.Compare
+	}
+
+	if naming.CreateUp == "" {
+		naming.CreateUp = preset.CreateUp
+	}
+
+	if naming.CreateDown == "" {
+		naming.CreateDown = preset.CreateDown
+	}
+
+	if naming.CreateSingle == "" {
+		naming.CreateSingle = preset.CreateSingle
+	}
+
+	if naming.VersionFormat == "" {
+		naming.VersionFormat = preset.VersionFormat
+	}
+
+	return naming
+}
+
+// compareNumeric compares versions as integers of arbitrary length.
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+// compareSemver compares versions like 1.2.10, v1_2 or 2.0.0-rc1 part by part.
+// Numeric parts are compared as numbers, other parts lexicographically. A
+// pre-release version (after "-") goes before the version without it.
+func compareSemver(a, b string) int {
+	coreA, preA := splitPrerelease(strings.TrimPrefix(a, "v"))
+	coreB, preB := splitPrerelease(strings.TrimPrefix(b, "v"))
+
+	if c := compareParts(coreA, coreB); c != 0 {
+		return c
+	}
+
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+
+	return compareParts(preA, preB)
+}
+
+// splitPrerelease splits the version into the core and the pre-release part.
+func splitPrerelease(v string) (string, string) {
+	if i := strings.Index(v, "-"); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+
+	return v, ""
+}
+
+// compareParts compares versions split by ".", "_" and "-" part by part, a
+// version with more parts goes after a version with less parts.
+func compareParts(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool {
+			return r == '.' || r == '_' || r == '-'
+		})
+	}
+
+	pa, pb := split(a), split(b)
+
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		var c int
+
+		if isDigits(pa[i]) && isDigits(pb[i]) {
+			c = compareNumeric(pa[i], pb[i])
+		} else {
+			c = strings.Compare(pa[i], pb[i])
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return len(pa) - len(pb)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return s != ""
+}
@@ -0,0 +1,172 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/librun/migrago/internal/config"
+)
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+
+	return 0
+}
+
+func TestCompareNumeric(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1", "1", 0},
+		{"2", "10", -1},
+		{"10", "2", 1},
+		{"001", "1", 0},
+		{"0010", "9", 1},
+		{"0", "00", 0},
+		{"20200101150405", "20200101150406", -1},
+		{"99999999999999999999", "100000000000000000000", -1},
+	}
+
+	for _, tt := range tests {
+		if got := sign(compareNumeric(tt.a, tt.b)); got != tt.want {
+			t.Errorf("compareNumeric(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2", "1.2", 0},
+		{"1.2", "1.10", -1},
+		{"1.10", "1.9", 1},
+		{"1.2", "1.2.1", -1},
+		{"v1.2", "1.2", 0},
+		{"1_2", "1.2", 0},
+		{"1.01", "1.1", 0},
+		{"1.02", "1.10", -1},
+		{"2.0.0-rc1", "2.0.0", -1},
+		{"2.0.0", "2.0.0-rc1", 1},
+		{"2.0.0-rc1", "2.0.0-rc2", -1},
+		{"2.0.0-alpha", "2.0.0-beta", -1},
+		{"2.0.0-rc.2", "2.0.0-rc.10", -1},
+		{"1.9.9", "2.0.0-rc1", -1},
+		{"1.a", "1.b", -1},
+	}
+
+	for _, tt := range tests {
+		if got := sign(compareSemver(tt.a, tt.b)); got != tt.want {
+			t.Errorf("compareSemver(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSchemeCompare(t *testing.T) {
+	tests := []struct {
+		preset string
+		a, b   string
+		want   int
+	}{
+		{PresetMigrago, "20200101_150405_b", "20200101_150405_a", 1},
+		{PresetMigrago, "9", "10", 1},
+		{PresetGolangMigrate, "9", "10", -1},
+		{PresetGoose, "00010", "9", 1},
+		{PresetFlyway, "1.9", "1.10", -1},
+	}
+
+	for _, tt := range tests {
+		scheme, err := NewScheme(config.Naming{Preset: tt.preset})
+		if err != nil {
+			t.Fatalf("NewScheme(%s): %v", tt.preset, err)
+		}
+
+		if got := sign(scheme.Compare(tt.a, tt.b)); got != tt.want {
+			t.Errorf("%s: Compare(%q, %q) = %d, want %d", tt.preset, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPresetMatch(t *testing.T) {
+	const (
+		up     = "up"
+		down   = "down"
+		single = "single"
+		repeat = "repeat"
+	)
+
+	tests := []struct {
+		preset  string
+		kind    string
+		file    string
+		version string
+		ok      bool
+	}{
+		{PresetMigrago, up, "20200101_150405_name_up.sql", "20200101_150405_name", true},
+		{PresetMigrago, down, "20200101_150405_name_down.sql", "20200101_150405_name", true},
+		{PresetMigrago, single, "20200101_150405_name.sql", "20200101_150405_name", true},
+		{PresetMigrago, repeat, "views_repeat.sql", "views", true},
+		{PresetMigrago, up, "20200101_150405_name.sql", "", false},
+		{PresetMigrago, up, "_up.sql", "", false},
+
+		{PresetGolangMigrate, up, "1_create.up.sql", "1", true},
+		{PresetGolangMigrate, down, "20200101150405_create.down.sql", "20200101150405", true},
+		{PresetGolangMigrate, up, "1_create.down.sql", "", false},
+		{PresetGolangMigrate, up, "v1_create.up.sql", "", false},
+		{PresetGolangMigrate, single, "1_create.sql", "", false},
+		{PresetGolangMigrate, repeat, "views_repeat.sql", "", false},
+
+		{PresetFlyway, up, "V1.2__create.sql", "1.2", true},
+		{PresetFlyway, up, "V1_2__create.sql", "1_2", true},
+		{PresetFlyway, down, "U1.2__create.sql", "1.2", true},
+		{PresetFlyway, repeat, "R__views.sql", "views", true},
+		{PresetFlyway, up, "V1.2_create.sql", "", false},
+		{PresetFlyway, up, "Va__create.sql", "", false},
+		{PresetFlyway, up, "R__views.sql", "", false},
+
+		{PresetGoose, single, "20200101150405_create.sql", "20200101150405", true},
+		{PresetGoose, single, "create.sql", "", false},
+		{PresetGoose, up, "20200101150405_create_up.sql", "", false},
+	}
+
+	for _, tt := range tests {
+		scheme, err := NewScheme(config.Naming{Preset: tt.preset})
+		if err != nil {
+			t.Fatalf("NewScheme(%s): %v", tt.preset, err)
+		}
+
+		parse := map[string]func(string) (string, bool){
+			up:     scheme.parseUp,
+			down:   scheme.parseDown,
+			single: scheme.parseSingle,
+			repeat: scheme.parseRepeat,
+		}[tt.kind]
+
+		version, ok := parse(tt.file)
+		if ok != tt.ok || version != tt.version {
+			t.Errorf("%s %s %q: got (%q, %v), want (%q, %v)", tt.preset, tt.kind, tt.file, version, ok,
+				tt.version, tt.ok)
+		}
+	}
+}
+
+func TestNewSchemeErrors(t *testing.T) {
+	tests := []config.Naming{
+		{Preset: "unknown"},
+		{Compare: "random"},
+		{Up: `^(.+)_up\.sql$`},
+		{Up: `^(?P<version>[`},
+	}
+
+	for _, naming := range tests {
+		if _, err := NewScheme(naming); err == nil {
+			t.Errorf("NewScheme(%+v): expected error", naming)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package migration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// Repeatable is a migration without a version, such as a definition of views
+// or functions. It is applied after versioned migrations whenever its checksum
+// changes.
+type Repeatable struct {
+	Name string
+	Path string
+}
+
+// ScanRepeatable finds repeatable migrations in the directories and returns them
+// sorted by name. The same name in two files is an error.
+func ScanRepeatable(dirs []string, scheme *Scheme) ([]Repeatable, error) {
+	var repeatables []Repeatable
+
+	paths := map[string]string{}
+
+	for _, dir := range dirs {
+		filesInDir, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("get files list: %w", err)
+		}
+
+		for _, f := range filesInDir {
+			if f.IsDir() {
+				continue
+			}
+
+			name, ok := scheme.parseRepeat(f.Name())
+			if !ok {
+				continue
+			}
+
+			path := filepath.Join(dir, f.Name())
+			if other, ok := paths[name]; ok {
+				return nil, fmt.Errorf("repeatable migration %s found in files %s and %s", name, other, path)
+			}
+
+			paths[name] = path
+			repeatables = append(repeatables, Repeatable{Name: name, Path: path})
+		}
+	}
+
+	sort.Slice(repeatables, func(i, j int) bool {
+		return repeatables[i].Name < repeatables[j].Name
+	})
+
+	return repeatables, nil
+}
+
+// Read returns query of the repeatable migration.
+func (r *Repeatable) Read() (string, error) {
+	content, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+
+	return string(content), nil
+}
@@ -0,0 +1,42 @@
+package migration
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Markers of sections in a single migration file.
+const (
+	MarkerUp   = "-- +migrago Up"
+	MarkerDown = "-- +migrago Down"
+)
+
+// markerRe matches section markers of migrago and goose: "-- +migrago Up", "-- +goose Down".
+var markerRe = regexp.MustCompile(`(?i)^\s*--\s*\+(?:migrago|goose)\s+(up|down)\s*$`)
+
+// parseSections splits a single migration file into up and down sections.
+// Text before the first marker is ignored. ok is false if there is no up marker.
+func parseSections(content string) (up, down string, ok bool) {
+	var upLines, downLines []string
+
+	var current *[]string
+
+	for _, line := range strings.Split(content, "\n") {
+		if m := markerRe.FindStringSubmatch(strings.TrimRight(line, "\r")); m != nil {
+			if strings.EqualFold(m[1], "up") {
+				current = &upLines
+				ok = true
+			} else {
+				current = &downLines
+			}
+
+			continue
+		}
+
+		if current != nil {
+			*current = append(*current, line)
+		}
+	}
+
+	return strings.Join(upLines, "\n"), strings.Join(downLines, "\n"), ok
+}
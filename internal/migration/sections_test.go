@@ -0,0 +1,75 @@
+package migration
+
+import "testing"
+
+func TestParseSections(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		up, down string
+		ok       bool
+	}{
+		{
+			name:    "up and down",
+			content: "-- +migrago Up\nCREATE TABLE t (id int);\n-- +migrago Down\nDROP TABLE t;\n",
+			up:      "CREATE TABLE t (id int);",
+			down:    "DROP TABLE t;\n",
+			ok:      true,
+		},
+		{
+			name:    "up only",
+			content: "-- +migrago Up\nCREATE TABLE t (id int);",
+			up:      "CREATE TABLE t (id int);",
+			ok:      true,
+		},
+		{
+			name:    "text before the first marker",
+			content: "-- comment\nSELECT 1;\n-- +migrago Up\nSELECT 2;",
+			up:      "SELECT 2;",
+			ok:      true,
+		},
+		{
+			name:    "goose markers in any case",
+			content: "--  +goose up\nSELECT 1;\n-- +GOOSE DOWN\nSELECT 2;",
+			up:      "SELECT 1;",
+			down:    "SELECT 2;",
+			ok:      true,
+		},
+		{
+			name:    "crlf",
+			content: "-- +migrago Up\r\nSELECT 1;\r\n-- +migrago Down\r\nSELECT 2;",
+			up:      "SELECT 1;\r",
+			down:    "SELECT 2;",
+			ok:      true,
+		},
+		{
+			name:    "down before up",
+			content: "-- +migrago Down\nSELECT 2;\n-- +migrago Up\nSELECT 1;",
+			up:      "SELECT 1;",
+			down:    "SELECT 2;",
+			ok:      true,
+		},
+		{
+			name:    "squashed markers are not sections",
+			content: "-- +migrago Up\n-- +migrago Squashed 001_a\nSELECT 1;",
+			up:      "-- +migrago Squashed 001_a\nSELECT 1;",
+			ok:      true,
+		},
+		{
+			name:    "no up marker",
+			content: "SELECT 1;\n-- +migrago Down\nSELECT 2;",
+			down:    "SELECT 2;",
+		},
+		{
+			name:    "marker in a statement",
+			content: "SELECT '-- +migrago Up';",
+		},
+	}
+
+	for _, tt := range tests {
+		up, down, ok := parseSections(tt.content)
+		if up != tt.up || down != tt.down || ok != tt.ok {
+			t.Errorf("%s: got (%q, %q, %v), want (%q, %q, %v)", tt.name, up, down, ok, tt.up, tt.down, tt.ok)
+		}
+	}
+}
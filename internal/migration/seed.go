@@ -0,0 +1,89 @@
+package migration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// seedExt is the extension of seed files.
+const seedExt = ".sql"
+
+// ScanSeeds finds seeds in the directories. Seeds in a directory are applied
+// in all environments, seeds in its subdirectory named after the environment
+// are applied in that environment only and are named "env/name". Seeds of all
+// environments come first, each part is sorted by name. Seeds are returned as
+// repeatable migrations: they are applied again when their checksum changes.
+func ScanSeeds(dirs []string, env string) ([]Repeatable, error) {
+	if env != "" && (env == "." || env == ".." || strings.ContainsAny(env, `/\`)) {
+		return nil, fmt.Errorf("invalid environment %s", env)
+	}
+
+	var common, scoped []Repeatable
+
+	paths := map[string]string{}
+
+	for _, dir := range dirs {
+		seeds, err := scanSeedDir(dir, "", paths)
+		if err != nil {
+			return nil, err
+		}
+
+		common = append(common, seeds...)
+
+		if env == "" {
+			continue
+		}
+
+		envDir := filepath.Join(dir, env)
+		if fi, err := os.Stat(envDir); err != nil || !fi.IsDir() {
+			continue
+		}
+
+		seeds, err = scanSeedDir(envDir, env+"/", paths)
+		if err != nil {
+			return nil, err
+		}
+
+		scoped = append(scoped, seeds...)
+	}
+
+	for _, seeds := range [][]Repeatable{common, scoped} {
+		sort.Slice(seeds, func(i, j int) bool {
+			return seeds[i].Name < seeds[j].Name
+		})
+	}
+
+	return append(common, scoped...), nil
+}
+
+// scanSeedDir returns seeds of the directory, paths contains paths of found seeds by name.
+func scanSeedDir(dir, prefix string, paths map[string]string) ([]Repeatable, error) {
+	filesInDir, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("get files list: %w", err)
+	}
+
+	var seeds []Repeatable
+
+	for _, f := range filesInDir {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), seedExt) {
+			continue
+		}
+
+		name := prefix + strings.TrimSuffix(f.Name(), seedExt)
+		path := filepath.Join(dir, f.Name())
+
+		if other, ok := paths[name]; ok {
+			return nil, fmt.Errorf("seed %s found in files %s and %s", name, other, path)
+		}
+
+		paths[name] = path
+		seeds = append(seeds, Repeatable{Name: name, Path: path})
+	}
+
+	return seeds, nil
+}
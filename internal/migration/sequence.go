@@ -0,0 +1,168 @@
+package migration
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// sequenceDigitsDefault is a default width of zero-padded sequence versions.
+const sequenceDigitsDefault = 6
+
+// Rename describes renaming of a migration to a new sequence number.
+type Rename struct {
+	Version    string `json:"version"`
+	NewVersion string `json:"new_version"`
+	// Paths maps old file paths to new ones.
+	Paths map[string]string `json:"paths"`
+}
+
+// sequenceOf returns the leading number of the version.
+func sequenceOf(version string) (*big.Int, bool) {
+	digits := leadingDigits(version)
+	if digits == "" {
+		return nil, false
+	}
+
+	n, ok := new(big.Int).SetString(digits, 10)
+
+	return n, ok
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+
+	return s[:i]
+}
+
+// nextSequence returns the number following the largest sequence among files.
+func nextSequence(files []File) *big.Int {
+	next := big.NewInt(0)
+
+	for _, f := range files {
+		if n, ok := sequenceOf(f.Version); ok && n.Cmp(next) >= 0 {
+			next.Add(n, big.NewInt(1))
+		}
+	}
+
+	if next.Sign() == 0 {
+		next.SetInt64(1)
+	}
+
+	return next
+}
+
+func (s *Scheme) formatSequence(n *big.Int) string {
+	seq := n.String()
+	if len(seq) < s.seqDigits {
+		seq = strings.Repeat("0", s.seqDigits-len(seq)) + seq
+	}
+
+	return seq
+}
+
+// PlanRenumber resolves duplicate and out-of-order sequence numbers of unapplied
+// migrations. Applied migrations keep their numbers; an unapplied migration gets
+// a new number if it is not greater than the number of the previous migration.
+func (s *Scheme) PlanRenumber(files []File, applied map[string]bool) ([]Rename, error) {
+	last := big.NewInt(0)
+
+	// Unapplied migrations must follow all applied ones.
+	for _, f := range files {
+		if n, ok := sequenceOf(f.Version); ok && applied[f.Version] && n.Cmp(last) > 0 {
+			last.Set(n)
+		}
+	}
+
+	var renames []Rename
+
+	for _, f := range files {
+		if applied[f.Version] {
+			continue
+		}
+
+		n, ok := sequenceOf(f.Version)
+		if !ok {
+			return nil, fmt.Errorf("migration %s has no sequence number", f.Version)
+		}
+
+		if n.Cmp(last) > 0 {
+			last.Set(n)
+			continue
+		}
+
+		last.Add(last, big.NewInt(1))
+
+		rename, err := s.rename(f, s.formatSequence(last))
+		if err != nil {
+			return nil, err
+		}
+
+		renames = append(renames, rename)
+	}
+
+	return renames, nil
+}
+
+// Apply renames the migration files.
+func (r *Rename) Apply() error {
+	for oldPath, newPath := range r.Paths {
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("rename %s: file %s already exists", oldPath, newPath)
+		}
+	}
+
+	for oldPath, newPath := range r.Paths {
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// rename returns new paths of the migration files with the sequence number replaced.
+func (s *Scheme) rename(f File, seq string) (Rename, error) {
+	r := Rename{Version: f.Version, Paths: map[string]string{}}
+
+	type fileKind struct {
+		path string
+		re   *regexp.Regexp
+	}
+
+	kinds := []fileKind{{f.UpPath, s.up}}
+	if f.Single {
+		kinds = []fileKind{{f.UpPath, s.single}}
+	} else if f.DownPath != "" {
+		kinds = append(kinds, fileKind{f.DownPath, s.down})
+	}
+
+	for _, k := range kinds {
+		name := filepath.Base(k.path)
+
+		loc := k.re.FindStringSubmatchIndex(name)
+		if loc == nil {
+			return r, fmt.Errorf("file %s does not match naming", name)
+		}
+
+		start, end := loc[2*versionIndex(k.re)], loc[2*versionIndex(k.re)+1]
+		version := name[start:end]
+		digits := leadingDigits(version)
+
+		newVersion := seq + version[len(digits):]
+		r.NewVersion = newVersion
+		r.Paths[k.path] = filepath.Join(filepath.Dir(k.path), name[:start]+newVersion+name[end:])
+	}
+
+	if r.NewVersion == "" || r.NewVersion == f.Version {
+		return r, fmt.Errorf("migration %s can not be renumbered", f.Version)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,142 @@
+package migration
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librun/migrago/internal/config"
+)
+
+func sequenceFiles(versions ...string) []File {
+	files := make([]File, 0, len(versions))
+	for _, v := range versions {
+		files = append(files, File{
+			Version:  v,
+			UpPath:   filepath.Join("m", v+postfixUp),
+			DownPath: filepath.Join("m", v+postfixDown),
+		})
+	}
+
+	return files
+}
+
+func TestPlanRenumber(t *testing.T) {
+	scheme, err := NewScheme(config.Naming{Sequence: true, SequenceDigits: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		files   []File
+		applied []string
+		// want maps renamed versions to new versions.
+		want map[string]string
+	}{
+		{
+			name:  "sequential",
+			files: sequenceFiles("001_a", "002_b", "003_c"),
+			want:  map[string]string{},
+		},
+		{
+			name:  "gaps are kept",
+			files: sequenceFiles("001_a", "005_b", "010_c"),
+			want:  map[string]string{},
+		},
+		{
+			name:    "collision with unapplied",
+			files:   sequenceFiles("001_a", "002_b", "002_c", "003_d"),
+			applied: []string{"001_a", "002_b"},
+			want:    map[string]string{"002_c": "003_c", "003_d": "004_d"},
+		},
+		{
+			name:    "collision with applied",
+			files:   sequenceFiles("001_a", "002_b", "002_c"),
+			applied: []string{"001_a", "002_c"},
+			want:    map[string]string{"002_b": "003_b"},
+		},
+		{
+			name:    "older than applied",
+			files:   sequenceFiles("001_a", "002_b", "005_c", "007_d"),
+			applied: []string{"001_a", "005_c"},
+			want:    map[string]string{"002_b": "006_b"},
+		},
+		{
+			name:    "renumbered chain",
+			files:   sequenceFiles("001_a", "001_b", "002_c", "002_d"),
+			applied: []string{"001_a"},
+			want:    map[string]string{"001_b": "002_b", "002_c": "003_c", "002_d": "004_d"},
+		},
+		{
+			name:    "all applied",
+			files:   sequenceFiles("001_a", "001_b"),
+			applied: []string{"001_a", "001_b"},
+			want:    map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		applied := map[string]bool{}
+		for _, v := range tt.applied {
+			applied[v] = true
+		}
+
+		renames, err := scheme.PlanRenumber(tt.files, applied)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		got := map[string]string{}
+
+		for _, r := range renames {
+			got[r.Version] = r.NewVersion
+
+			wantPaths := map[string]string{
+				filepath.Join("m", r.Version+postfixUp):   filepath.Join("m", r.NewVersion+postfixUp),
+				filepath.Join("m", r.Version+postfixDown): filepath.Join("m", r.NewVersion+postfixDown),
+			}
+			if !reflect.DeepEqual(r.Paths, wantPaths) {
+				t.Errorf("%s: paths of %s = %v, want %v", tt.name, r.Version, r.Paths, wantPaths)
+			}
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: renames = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlanRenumberSingle(t *testing.T) {
+	scheme, err := NewScheme(config.Naming{Preset: PresetGoose, Sequence: true, SequenceDigits: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []File{
+		{Version: "00001", UpPath: "m/00001_a.sql", DownPath: "m/00001_a.sql", Single: true},
+		{Version: "00001", UpPath: "m/00001_b.sql", DownPath: "m/00001_b.sql", Single: true},
+	}
+
+	renames, err := scheme.PlanRenumber(files, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Rename{{Version: "00001", NewVersion: "00002", Paths: map[string]string{"m/00001_b.sql": "m/00002_b.sql"}}}
+	if !reflect.DeepEqual(renames, want) {
+		t.Errorf("renames = %+v, want %+v", renames, want)
+	}
+}
+
+func TestPlanRenumberNoSequence(t *testing.T) {
+	scheme, err := NewScheme(config.Naming{Sequence: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := scheme.PlanRenumber(sequenceFiles("001_a", "name_b"), map[string]bool{}); err == nil {
+		t.Error("expected error for a version without a sequence number")
+	}
+}
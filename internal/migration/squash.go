@@ -0,0 +1,184 @@
+package migration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// baselineHeader is the first line of baseline migrations.
+const baselineHeader = "-- Baseline migration squashed by migrago."
+
+// MarkerSquashed marks a version squashed into a baseline migration:
+// "-- +migrago Squashed 20200101_150405_name".
+const MarkerSquashed = "-- +migrago Squashed"
+
+// squashedRe matches squashed version markers.
+var squashedRe = regexp.MustCompile(`(?i)^\s*--\s*\+migrago\s+squashed\s+(\S+)\s*$`)
+
+// Squash describes squashing of the oldest migrations into a baseline
+// migration. The baseline takes the version and the files of the newest
+// squashed migration, so databases which applied it skip the baseline.
+type Squash struct {
+	Version string
+	// Squashed are versions replaced by the baseline, oldest first, including
+	// the version of the baseline. An earlier baseline is squashed as a whole.
+	Squashed []string
+	Up       string
+	// Down is empty if some of the squashed migrations have no down files.
+	Down string
+	// Removed are files of the squashed migrations deleted by Apply.
+	Removed []string
+
+	baseline File
+}
+
+// Squashed returns versions squashed into the migration, nil if the migration
+// is not a baseline.
+func (f *File) Squashed() ([]string, error) {
+	content, err := ioutil.ReadFile(f.UpPath)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	var versions []string
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if m := squashedRe.FindStringSubmatch(strings.TrimRight(line, "\r")); m != nil {
+			versions = append(versions, m[1])
+		}
+	}
+
+	return versions, nil
+}
+
+// PlanSquash squashes migrations up to the version, files must be sorted. Up
+// migrations are concatenated into the baseline, unless the schema is set: the
+// baseline creates the schema then and has no down migration.
+func PlanSquash(files []File, version, schema string) (Squash, error) {
+	last := -1
+
+	for i := range files {
+		if files[i].Version == version {
+			last = i
+		}
+	}
+
+	if last < 0 {
+		return Squash{}, fmt.Errorf("migration %s not found", version)
+	}
+
+	if last == 0 {
+		return Squash{}, fmt.Errorf("nothing to squash: %s is the oldest migration", version)
+	}
+
+	squash := Squash{Version: version, baseline: files[last]}
+
+	ups := make([]string, 0, last+1)
+	downs := make([]string, 0, last+1)
+	rollback := true
+
+	for i := 0; i <= last; i++ {
+		file := files[i]
+		squash.Squashed = append(squash.Squashed, file.Version)
+
+		up, err := file.ReadUp()
+		if err != nil {
+			return Squash{}, err
+		}
+
+		ups = append(ups, "-- Migration "+file.Version+"\n"+stripSquashed(up))
+
+		if !file.Rollback() {
+			rollback = false
+		} else if rollback {
+			down, err := file.ReadDown()
+			if err != nil {
+				return Squash{}, err
+			}
+
+			downs = append([]string{"-- Migration " + file.Version + "\n" + stripSquashed(down)}, downs...)
+		}
+
+		if i < last {
+			squash.Removed = append(squash.Removed, file.UpPath)
+			if file.DownPath != "" && file.DownPath != file.UpPath {
+				squash.Removed = append(squash.Removed, file.DownPath)
+			}
+		}
+	}
+
+	header := baselineHeader + "\n"
+	for _, v := range squash.Squashed {
+		header += MarkerSquashed + " " + v + "\n"
+	}
+
+	if schema != "" {
+		squash.Up = header + "\n" + schema + "\n"
+		return squash, nil
+	}
+
+	squash.Up = header + "\n" + strings.Join(ups, "\n\n") + "\n"
+
+	if rollback {
+		squash.Down = strings.Join(downs, "\n\n") + "\n"
+	}
+
+	return squash, nil
+}
+
+// Apply writes the baseline migration and deletes files of the other squashed migrations.
+func (s *Squash) Apply() error {
+	up := s.Up
+
+	if s.baseline.Single {
+		up = MarkerUp + "\n" + s.Up
+		if s.Down != "" {
+			up += "\n" + MarkerDown + "\n" + s.Down
+		}
+	}
+
+	if err := ioutil.WriteFile(s.baseline.UpPath, []byte(up), 0644); err != nil {
+		return fmt.Errorf("write baseline: %w", err)
+	}
+
+	if !s.baseline.Single && s.baseline.DownPath != "" {
+		var err error
+
+		if s.Down != "" {
+			err = ioutil.WriteFile(s.baseline.DownPath, []byte(s.Down), 0644)
+		} else {
+			err = os.Remove(s.baseline.DownPath)
+		}
+
+		if err != nil {
+			return fmt.Errorf("write baseline: %w", err)
+		}
+	}
+
+	for _, path := range s.Removed {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove squashed migration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// stripSquashed returns the query without the baseline header, squashed
+// version markers and surrounding whitespace.
+func stripSquashed(query string) string {
+	lines := strings.Split(strings.TrimSpace(query), "\n")
+	kept := lines[:0]
+
+	for _, line := range lines {
+		line := strings.TrimRight(line, "\r")
+		if line != baselineHeader && !squashedRe.MatchString(line) {
+			kept = append(kept, line)
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
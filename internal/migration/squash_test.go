@@ -0,0 +1,221 @@
+package migration
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanSquash(t *testing.T) {
+	dir, remove := writeMigrations(t, map[string]string{
+		"001_a_up.sql":   "CREATE TABLE a (id int);\n",
+		"001_a_down.sql": "DROP TABLE a;\n",
+		"002_b_up.sql":   "CREATE TABLE b (id int);\n",
+		"002_b_down.sql": "DROP TABLE b;\n",
+		"003_c_up.sql":   "CREATE TABLE c (id int);\n",
+	})
+	defer remove()
+
+	files := scanMigrations(t, dir)
+
+	squash, err := PlanSquash(files, "002_b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"001_a", "002_b"}; !reflect.DeepEqual(squash.Squashed, want) {
+		t.Errorf("squashed = %v, want %v", squash.Squashed, want)
+	}
+
+	wantRemoved := []string{filepath.Join(dir, "001_a_up.sql"), filepath.Join(dir, "001_a_down.sql")}
+	if !reflect.DeepEqual(squash.Removed, wantRemoved) {
+		t.Errorf("removed = %v, want %v", squash.Removed, wantRemoved)
+	}
+
+	wantUp := baselineHeader + "\n" +
+		MarkerSquashed + " 001_a\n" +
+		MarkerSquashed + " 002_b\n" +
+		"\n-- Migration 001_a\nCREATE TABLE a (id int);\n\n-- Migration 002_b\nCREATE TABLE b (id int);\n"
+	if squash.Up != wantUp {
+		t.Errorf("up = %q, want %q", squash.Up, wantUp)
+	}
+
+	wantDown := "-- Migration 002_b\nDROP TABLE b;\n\n-- Migration 001_a\nDROP TABLE a;\n"
+	if squash.Down != wantDown {
+		t.Errorf("down = %q, want %q", squash.Down, wantDown)
+	}
+
+	if err := squash.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	files = scanMigrations(t, dir)
+	if len(files) != 2 || files[0].Version != "002_b" || files[1].Version != "003_c" {
+		t.Fatalf("files after apply = %+v", files)
+	}
+
+	versions, err := files[0].Squashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"001_a", "002_b"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("squashed markers = %v, want %v", versions, want)
+	}
+
+	if down, err := files[0].ReadDown(); err != nil || down != wantDown {
+		t.Errorf("down file = %q, %v, want %q", down, err, wantDown)
+	}
+}
+
+func TestPlanSquashWithoutDown(t *testing.T) {
+	dir, remove := writeMigrations(t, map[string]string{
+		"001_a_up.sql":   "SELECT 1;",
+		"001_a_down.sql": "SELECT -1;",
+		"002_b_up.sql":   "SELECT 2;",
+		"003_c_up.sql":   "SELECT 3;",
+		"003_c_down.sql": "SELECT -3;",
+	})
+	defer remove()
+
+	files := scanMigrations(t, dir)
+
+	squash, err := PlanSquash(files, "003_c", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if squash.Down != "" {
+		t.Errorf("down = %q, want none: 002_b has no down file", squash.Down)
+	}
+
+	if err := squash.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The down file of the baseline is removed.
+	files = scanMigrations(t, dir)
+	if len(files) != 1 || files[0].Rollback() {
+		t.Errorf("files after apply = %+v, want the baseline without down file", files)
+	}
+}
+
+func TestPlanSquashFromSchema(t *testing.T) {
+	dir, remove := writeMigrations(t, map[string]string{
+		"001_a_up.sql":   "CREATE TABLE a (id int);",
+		"001_a_down.sql": "DROP TABLE a;",
+		"002_b_up.sql":   "ALTER TABLE a ADD b int;",
+		"002_b_down.sql": "ALTER TABLE a DROP b;",
+	})
+	defer remove()
+
+	schema := "CREATE TABLE a (\n    id integer,\n    b integer\n);"
+
+	squash, err := PlanSquash(scanMigrations(t, dir), "002_b", schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantUp := baselineHeader + "\n" + MarkerSquashed + " 001_a\n" + MarkerSquashed + " 002_b\n\n" + schema + "\n"
+	if squash.Up != wantUp || squash.Down != "" {
+		t.Errorf("got (%q, %q), want (%q, \"\")", squash.Up, squash.Down, wantUp)
+	}
+}
+
+func TestPlanSquashNested(t *testing.T) {
+	baseline := baselineHeader + "\n" + MarkerSquashed + " 001_a\n" + MarkerSquashed + " 002_b\n\n" +
+		"-- Migration 001_a\nSELECT 1;\n\n-- Migration 002_b\nSELECT 2;\n"
+
+	dir, remove := writeMigrations(t, map[string]string{
+		"002_b_up.sql": baseline,
+		"003_c_up.sql": "SELECT 3;",
+	})
+	defer remove()
+
+	squash, err := PlanSquash(scanMigrations(t, dir), "003_c", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"002_b", "003_c"}; !reflect.DeepEqual(squash.Squashed, want) {
+		t.Errorf("squashed = %v, want %v", squash.Squashed, want)
+	}
+
+	// Markers of the earlier baseline are not repeated in its section.
+	if n := strings.Count(squash.Up, baselineHeader); n != 1 {
+		t.Errorf("baseline header found %d times in %q", n, squash.Up)
+	}
+
+	if n := strings.Count(squash.Up, MarkerSquashed); n != 2 {
+		t.Errorf("squashed markers found %d times in %q", n, squash.Up)
+	}
+
+	if !strings.Contains(squash.Up, "-- Migration 002_b\n-- Migration 001_a\nSELECT 1;") {
+		t.Errorf("up = %q, want the earlier baseline under its version", squash.Up)
+	}
+}
+
+func TestPlanSquashSingle(t *testing.T) {
+	dir, remove := writeMigrations(t, map[string]string{
+		"001_a.sql": MarkerUp + "\nSELECT 1;\n" + MarkerDown + "\nSELECT -1;\n",
+		"002_b.sql": MarkerUp + "\nSELECT 2;\n" + MarkerDown + "\nSELECT -2;\n",
+	})
+	defer remove()
+
+	squash, err := PlanSquash(scanMigrations(t, dir), "002_b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := squash.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := scanMigrations(t, dir)
+	if len(files) != 1 || !files[0].Single {
+		t.Fatalf("files after apply = %+v, want one single file", files)
+	}
+
+	up, err := files[0].ReadUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down, err := files[0].ReadDown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(up, "SELECT 1;") || !strings.Contains(up, "SELECT 2;") || strings.Contains(up, "SELECT -") {
+		t.Errorf("up section = %q", up)
+	}
+
+	if !strings.Contains(down, "-- Migration 002_b\nSELECT -2;\n\n-- Migration 001_a\nSELECT -1;") {
+		t.Errorf("down section = %q", down)
+	}
+}
+
+func TestPlanSquashErrors(t *testing.T) {
+	dir, remove := writeMigrations(t, map[string]string{
+		"001_a_up.sql": "SELECT 1;",
+		"002_b_up.sql": "SELECT 2;",
+	})
+	defer remove()
+
+	files := scanMigrations(t, dir)
+
+	for _, version := range []string{"001_a", "003_c"} {
+		if _, err := PlanSquash(files, version, ""); err == nil {
+			t.Errorf("PlanSquash(%s): expected error", version)
+		}
+	}
+}
+
+func TestStripSquashed(t *testing.T) {
+	query := "\n" + baselineHeader + "\n" + MarkerSquashed + " 001_a\r\n\n-- Migration 001_a\nSELECT 1;\n\n"
+
+	if got, want := stripSquashed(query), "-- Migration 001_a\nSELECT 1;"; got != want {
+		t.Errorf("stripSquashed = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Formats of command results. Text results are the log lines of commands,
+// other formats are written to stdout while logs go to stderr.
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatTable = "table"
+)
+
+// Table is a command result which can be shown as a table.
+type Table interface {
+	// Table returns the column names and the rows of the table.
+	Table() (header []string, rows [][]string)
+}
+
+// Status is a result of commands which have no other results.
+type Status struct {
+	Status string `json:"status"`
+}
+
+// Table implements the Table interface.
+func (s Status) Table() ([]string, [][]string) {
+	return []string{"status"}, [][]string{{s.Status}}
+}
+
+// Check checks that the format is supported.
+func Check(format string) error {
+	switch format {
+	case FormatText, FormatJSON, FormatTable:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %s (text, json or table)", format)
+	}
+}
+
+// Write writes the result in the format. Nothing is written for text,
+// the result is already logged by the command.
+func Write(w io.Writer, format string, result Table) error {
+	switch format {
+	case FormatJSON:
+		content, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encode result: %w", err)
+		}
+
+		if _, err := w.Write(append(content, '\n')); err != nil {
+			return fmt.Errorf("write result: %w", err)
+		}
+	case FormatTable:
+		if err := writeTable(w, result); err != nil {
+			return fmt.Errorf("write result: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func writeTable(w io.Writer, result Table) error {
+	header, rows := result.Table()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if _, err := fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t"))); err != nil {
+		return err
+	}
+
+	// Tabs and line breaks of values would break the table.
+	clean := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = clean.Replace(cell)
+		}
+
+		if _, err := fmt.Fprintln(tw, strings.Join(cells, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
@@ -1,19 +1,89 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/boltdb/bolt"
 )
 
 const migrateDataFilePathDefault = "data/migrations.db"
 
+// Service buckets. Their names start with boltReservedPrefix and can not clash
+// with project buckets because project names can not contain a colon in practice.
+const (
+	boltReservedPrefix = "migrago:"
+	boltEventsBucket   = boltReservedPrefix + "events"
+	boltMetaBucket     = boltReservedPrefix + "meta"
+	boltScriptsBucket  = boltReservedPrefix + "scripts"
+)
+
+// boltSchemaVersionKey is a key of the schema version in the meta bucket.
+const boltSchemaVersionKey = "schema_version"
+
+// boltSchema contains steps of the storage schema. The step with index i
+// upgrades the schema to version i+1. Steps are never changed after a release,
+// new steps are appended.
+var boltSchema = []func(tx *bolt.Tx) error{
+	// 1: project and database buckets of the first release.
+	func(*bolt.Tx) error { return nil },
+	// 2: the history log.
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(boltEventsBucket))
+		return err
+	},
+	// 3: migration values of older releases are rewritten with all current fields.
+	func(tx *bolt.Tx) error {
+		return boltForEachDatabase(tx, func(_, _ []byte, bkt *bolt.Bucket) error {
+			// A bucket can not be modified during iteration, so values are collected first.
+			values := map[string][]byte{}
+
+			err := bkt.ForEach(func(k, v []byte) error {
+				mi, err := boltDecodeMigrate(v)
+				if err != nil {
+					return err
+				}
+
+				encoded, err := boltEncodeMigrate(&mi)
+				if err != nil {
+					return err
+				}
+
+				values[string(k)] = encoded
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for k, v := range values {
+				if err := bkt.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	},
+	// 4: records of scripts.
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(boltScriptsBucket))
+		return err
+	},
+}
+
 // BoltDB represents a collection of buckets persisted to a file on disk.
+// Its operations are local and short, so they ignore contexts.
 type BoltDB struct {
 	connect *bolt.DB
 }
@@ -32,9 +102,91 @@ func (b *BoltDB) Init(cfg *Config) error {
 		return fmt.Errorf("bolt: open: %w", err)
 	}
 
+	if err := b.upgrade(); err != nil {
+		return fmt.Errorf("bolt: %w", err)
+	}
+
 	return nil
 }
 
+// SchemaVersion returns the version of the storage schema.
+func (b *BoltDB) SchemaVersion() (int, error) {
+	if b.connect == nil {
+		return 0, errors.New("connect is lost")
+	}
+
+	version := 0
+
+	err := b.connect.View(func(tx *bolt.Tx) error {
+		version = boltSchemaVersion(tx)
+		return nil
+	})
+
+	return version, err
+}
+
+// upgrade applies pending steps of the storage schema in one transaction.
+func (b *BoltDB) upgrade() error {
+	return b.connect.Update(func(tx *bolt.Tx) error {
+		current := boltSchemaVersion(tx)
+
+		if current > len(boltSchema) {
+			return fmt.Errorf("storage schema version %d is newer than supported %d, upgrade migrago",
+				current, len(boltSchema))
+		}
+
+		if current == len(boltSchema) {
+			return nil
+		}
+
+		for version := current + 1; version <= len(boltSchema); version++ {
+			if err := boltSchema[version-1](tx); err != nil {
+				return fmt.Errorf("upgrade schema to version %d: %w", version, err)
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(boltMetaBucket))
+		if err != nil {
+			return fmt.Errorf("create meta bucket: %w", err)
+		}
+
+		return meta.Put([]byte(boltSchemaVersionKey), []byte(strconv.Itoa(len(boltSchema))))
+	})
+}
+
+// boltSchemaVersion returns the schema version, files without the meta bucket have version 0.
+func boltSchemaVersion(tx *bolt.Tx) int {
+	meta := tx.Bucket([]byte(boltMetaBucket))
+	if meta == nil {
+		return 0
+	}
+
+	version, err := strconv.Atoi(string(meta.Get([]byte(boltSchemaVersionKey))))
+	if err != nil {
+		return 0
+	}
+
+	return version
+}
+
+// boltForEachDatabase calls f for every database bucket of every project.
+func boltForEachDatabase(tx *bolt.Tx, f func(projectName, dbName []byte, bkt *bolt.Bucket) error) error {
+	return tx.ForEach(func(name []byte, bp *bolt.Bucket) error {
+		if strings.HasPrefix(string(name), boltReservedPrefix) {
+			return nil
+		}
+
+		return bp.ForEach(func(k, v []byte) error {
+			// Only nested buckets have nil values.
+			if v != nil {
+				return nil
+			}
+
+			return f(name, k, bp.Bucket(k))
+		})
+	})
+}
+
 // PreInit creates dir fo database file is not exists.
 func (b *BoltDB) PreInit(cfg *Config) error {
 	if cfg.Path == "" {
@@ -63,7 +215,7 @@ func (b *BoltDB) Close() error {
 
 // CreateProjectDB creates a new bucket for project and database if it doesn't
 // already exist.
-func (b *BoltDB) CreateProjectDB(projectName, dbName string) error {
+func (b *BoltDB) CreateProjectDB(_ context.Context, projectName, dbName string) error {
 	if b.connect == nil {
 		return errors.New("connect is lost")
 	}
@@ -89,7 +241,7 @@ func (b *BoltDB) CreateProjectDB(projectName, dbName string) error {
 }
 
 // CheckMigration checks the migration was done successfully.
-func (b *BoltDB) CheckMigration(projectName, dbName, version string) (bool, error) {
+func (b *BoltDB) CheckMigration(_ context.Context, projectName, dbName, version string) (bool, error) {
 	if b.connect == nil {
 		return false, errors.New("connect is lost")
 	}
@@ -108,12 +260,11 @@ func (b *BoltDB) CheckMigration(projectName, dbName, version string) (bool, erro
 		}
 
 		if v := bkt.Get([]byte(version)); v != nil {
-			mi := Migrate{}
-			err := json.Unmarshal(v, &mi)
+			mi, err := boltDecodeMigrate(v)
 			if err != nil {
 				return err
 			}
-			found = true
+			found = !mi.Failed
 		}
 
 		return nil
@@ -126,7 +277,45 @@ func (b *BoltDB) CheckMigration(projectName, dbName, version string) (bool, erro
 }
 
 // Up runs migration up.
-func (b *BoltDB) Up(post *Migrate) error {
+func (b *BoltDB) Up(_ context.Context, post *Migrate) error {
+	if b.connect == nil {
+		return errors.New("connect is lost")
+	}
+
+	return b.connect.Update(func(tx *bolt.Tx) error {
+		bp := tx.Bucket([]byte(post.Project))
+		if bp == nil {
+			return errors.New("Project " + post.Project + " not exists")
+		}
+
+		bkt := bp.Bucket([]byte(post.Database))
+		if bkt == nil {
+			return errors.New("Database " + post.Database + " not exists")
+		}
+
+		// A record of a failed attempt is replaced, a record of an applied migration is kept.
+		if v := bkt.Get([]byte(post.Version)); v != nil {
+			mi, err := boltDecodeMigrate(v)
+			if err != nil {
+				return err
+			}
+
+			if !mi.Failed {
+				return fmt.Errorf("%s %s: %w", post.Database, post.Version, ErrApplied)
+			}
+		}
+
+		encoded, err := boltEncodeMigrate(post)
+		if err != nil {
+			return err
+		}
+
+		return bkt.Put([]byte(post.Version), encoded)
+	})
+}
+
+// Replace saves the migration record, replacing any record of the version.
+func (b *BoltDB) Replace(_ context.Context, post *Migrate) error {
 	if b.connect == nil {
 		return errors.New("connect is lost")
 	}
@@ -142,7 +331,7 @@ func (b *BoltDB) Up(post *Migrate) error {
 			return errors.New("Database " + post.Database + " not exists")
 		}
 
-		encoded, err := json.Marshal(post)
+		encoded, err := boltEncodeMigrate(post)
 		if err != nil {
 			return err
 		}
@@ -152,7 +341,7 @@ func (b *BoltDB) Up(post *Migrate) error {
 }
 
 // GetLast gets a list of recent migrations.
-func (b *BoltDB) GetLast(projectName, dbName string, skipNoRollback bool, limit *int) ([]Migrate, error) {
+func (b *BoltDB) GetLast(_ context.Context, projectName, dbName string, skipNoRollback bool, limit *int) ([]Migrate, error) {
 	if b.connect == nil {
 		return nil, errors.New("connect is lost")
 	}
@@ -170,11 +359,16 @@ func (b *BoltDB) GetLast(projectName, dbName string, skipNoRollback bool, limit
 		}
 
 		err := bkt.ForEach(func(k, v []byte) error {
-			mi := Migrate{}
-			if err := json.Unmarshal(v, &mi); err != nil {
+			// Values saved by older releases have no new fields, they are decoded as zero values.
+			mi, err := boltDecodeMigrate(v)
+			if err != nil {
 				return err
 			}
 
+			if mi.Failed {
+				return nil
+			}
+
 			// Add rollback migrations only (or all if flag `rollback migrations only` == false).
 			if mi.RollFlag || !skipNoRollback {
 				migrates = append(migrates, mi)
@@ -206,7 +400,7 @@ func (b *BoltDB) GetLast(projectName, dbName string, skipNoRollback bool, limit
 }
 
 // Delete calls migration down.
-func (b *BoltDB) Delete(post *Migrate) error {
+func (b *BoltDB) Delete(_ context.Context, post *Migrate) error {
 	if b.connect == nil {
 		return errors.New("connect is lost")
 	}
@@ -229,3 +423,246 @@ func (b *BoltDB) Delete(post *Migrate) error {
 
 	return err
 }
+
+// GetAll returns records of all projects and databases, including failed ones.
+func (b *BoltDB) GetAll(context.Context) ([]Migrate, error) {
+	if b.connect == nil {
+		return nil, errors.New("connect is lost")
+	}
+
+	migrates := []Migrate{}
+
+	err := b.connect.View(func(tx *bolt.Tx) error {
+		return boltForEachDatabase(tx, func(projectName, dbName []byte, bkt *bolt.Bucket) error {
+			return bkt.ForEach(func(k, v []byte) error {
+				mi, err := boltDecodeMigrate(v)
+				if err != nil {
+					return err
+				}
+
+				// Bucket names are the source of truth for the record location.
+				mi.Project, mi.Database, mi.Version = string(projectName), string(dbName), string(k)
+				migrates = append(migrates, mi)
+
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("view: %w", err)
+	}
+
+	return migrates, nil
+}
+
+// AddEvent appends the event to the history log.
+func (b *BoltDB) AddEvent(_ context.Context, event *Event) error {
+	if b.connect == nil {
+		return errors.New("connect is lost")
+	}
+
+	return b.connect.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(boltEventsBucket))
+		if err != nil {
+			return fmt.Errorf("create events bucket: %w", err)
+		}
+
+		// Sequential keys keep events in the order of addition.
+		seq, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+
+		encoded, err := boltEncodeEvent(event)
+		if err != nil {
+			return err
+		}
+
+		return bkt.Put(key, encoded)
+	})
+}
+
+// GetEvents returns events matching the filter, oldest first.
+func (b *BoltDB) GetEvents(_ context.Context, filter EventFilter) ([]Event, error) {
+	if b.connect == nil {
+		return nil, errors.New("connect is lost")
+	}
+
+	events := []Event{}
+
+	err := b.connect.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(boltEventsBucket))
+		if bkt == nil {
+			return nil
+		}
+
+		return bkt.ForEach(func(k, v []byte) error {
+			event, err := boltDecodeEvent(v)
+			if err != nil {
+				return err
+			}
+
+			if filter.Match(&event) {
+				events = append(events, event)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("view: %w", err)
+	}
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[len(events)-filter.Limit:]
+	}
+
+	return events, nil
+}
+
+// boltScriptPrefix returns the key prefix of scripts of the kind applied to the database.
+func boltScriptPrefix(kind, projectName, dbName string) []byte {
+	return []byte(kind + "\x00" + projectName + "\x00" + dbName + "\x00")
+}
+
+// GetScripts returns applied scripts of the kind, sorted by name.
+func (b *BoltDB) GetScripts(_ context.Context, kind, projectName, dbName string) ([]Script, error) {
+	return b.getScripts(boltScriptPrefix(kind, projectName, dbName))
+}
+
+// GetAllScripts returns applied scripts of all kinds, projects and databases.
+func (b *BoltDB) GetAllScripts(_ context.Context) ([]Script, error) {
+	return b.getScripts(nil)
+}
+
+// getScripts returns applied scripts with keys starting with the prefix.
+func (b *BoltDB) getScripts(prefix []byte) ([]Script, error) {
+	if b.connect == nil {
+		return nil, errors.New("connect is lost")
+	}
+
+	scripts := []Script{}
+
+	err := b.connect.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(boltScriptsBucket))
+		if bkt == nil {
+			return nil
+		}
+
+		c := bkt.Cursor()
+
+		k, v := c.First()
+		if len(prefix) > 0 {
+			k, v = c.Seek(prefix)
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			script := Script{}
+			if err := json.Unmarshal(v, &script); err != nil {
+				return err
+			}
+
+			scripts = append(scripts, script)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("view: %w", err)
+	}
+
+	return scripts, nil
+}
+
+// SaveScript saves the applied script, replacing its previous record.
+func (b *BoltDB) SaveScript(_ context.Context, script *Script) error {
+	if b.connect == nil {
+		return errors.New("connect is lost")
+	}
+
+	return b.connect.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(boltScriptsBucket))
+		if err != nil {
+			return fmt.Errorf("create scripts bucket: %w", err)
+		}
+
+		encoded, err := json.Marshal(script)
+		if err != nil {
+			return err
+		}
+
+		key := append(boltScriptPrefix(script.Kind, script.Project, script.Database), script.Name...)
+
+		return bkt.Put(key, encoded)
+	})
+}
+
+// boltMigrate and boltEvent are the stored forms of Migrate and Event. Values
+// keep the JSON field names of earlier releases, the json tags of Migrate and
+// Event are the format of command results. The conversions below fail to
+// compile when the fields differ.
+type (
+	boltMigrate struct {
+		Project     string
+		Database    string
+		Version     string
+		ApplyTime   int64
+		RollFlag    bool
+		OutOfOrder  bool
+		DurationMs  int64
+		AppliedBy   string
+		Host        string
+		ToolVersion string
+		Checksum    string
+		Description string
+		Failed      bool
+		Error       string
+	}
+
+	boltEvent struct {
+		Time        int64
+		Type        string
+		Project     string
+		Database    string
+		Version     string
+		Actor       string
+		ToolVersion string
+		Description string
+		DurationMs  int64
+		Error       string
+	}
+)
+
+// boltEncodeMigrate encodes the migration record to a bucket value.
+func boltEncodeMigrate(m *Migrate) ([]byte, error) {
+	return json.Marshal(boltMigrate(*m))
+}
+
+// boltDecodeMigrate decodes a bucket value. Values saved by older releases
+// have no new fields, they are decoded as zero values.
+func boltDecodeMigrate(v []byte) (Migrate, error) {
+	var m boltMigrate
+	if err := json.Unmarshal(v, &m); err != nil {
+		return Migrate{}, err
+	}
+
+	return Migrate(m), nil
+}
+
+// boltEncodeEvent encodes the event to a value of the events bucket.
+func boltEncodeEvent(e *Event) ([]byte, error) {
+	return json.Marshal(boltEvent(*e))
+}
+
+// boltDecodeEvent decodes a value of the events bucket.
+func boltDecodeEvent(v []byte) (Event, error) {
+	var e boltEvent
+	if err := json.Unmarshal(v, &e); err != nil {
+		return Event{}, err
+	}
+
+	return Event(e), nil
+}
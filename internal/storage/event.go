@@ -0,0 +1,60 @@
+package storage
+
+// Types of history events.
+const (
+	EventApply    = "apply"
+	EventRollback = "rollback"
+	EventMark     = "mark"
+	EventUnmark   = "unmark"
+	EventFailure  = "failure"
+	// EventSquash replaces records of squashed migrations by the record of their baseline.
+	EventSquash = "squash"
+	// EventRepeat is an application of a repeatable migration, the version is its name.
+	EventRepeat = "repeat"
+	// EventSeed is an application of a seed, the version is its name.
+	EventSeed = "seed"
+)
+
+type (
+	// Event is a record of the append-only history log.
+	Event struct {
+		// Time is a unix time of the event.
+		Time     int64  `json:"time"`
+		Type     string `json:"type"`
+		Project  string `json:"project"`
+		Database string `json:"database"`
+		Version  string `json:"version"`
+		// Actor is the OS user and the hostname: user@host.
+		Actor       string `json:"actor"`
+		ToolVersion string `json:"tool_version"`
+		Description string `json:"description"`
+		DurationMs  int64  `json:"duration_ms"`
+		Error       string `json:"error"`
+	}
+
+	// EventFilter limits the history log. Empty fields match all events.
+	EventFilter struct {
+		Project  string
+		Database string
+		Version  string
+		// Since and Until are unix times, zero means no limit.
+		Since int64
+		Until int64
+		// Limit is the maximum number of the newest events, zero means no limit.
+		Limit int
+	}
+)
+
+// Match checks that the event matches the filter.
+func (f *EventFilter) Match(e *Event) bool {
+	switch {
+	case f.Project != "" && e.Project != f.Project,
+		f.Database != "" && e.Database != f.Database,
+		f.Version != "" && e.Version != f.Version,
+		f.Since != 0 && e.Time < f.Since,
+		f.Until != 0 && e.Time > f.Until:
+		return false
+	}
+
+	return true
+}
@@ -1,59 +1,265 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq" // init postgresql driver.
+	"github.com/lib/pq"
+	"github.com/librun/migrago/internal/database"
 )
 
+// postgresTableDefault is a default name of the migration table.
+const postgresTableDefault = "migration"
+
 // PostgreSQL is a database handle representing a pool of zero or more
 // underlying connections.
 type PostgreSQL struct {
 	connect *sql.DB
+	tables  postgresTables
+}
+
+// postgresTables contains quoted names of migrago tables and their objects.
+// Names are derived from the configured migration table name.
+type postgresTables struct {
+	// migration, event, schema and script are qualified table names.
+	migration string
+	event     string
+	schema    string
+	script    string
+	// pk, eventIdx and scriptPk are names of the primary keys and the index,
+	// which are created in the schema of the table.
+	pk       string
+	eventIdx string
+	scriptPk string
+}
+
+func newPostgresTables(cfg *Config) postgresTables {
+	name := cfg.Table
+	if name == "" {
+		name = postgresTableDefault
+	}
+
+	qualify := func(table string) string {
+		if cfg.TableSchema == "" {
+			return pq.QuoteIdentifier(table)
+		}
+
+		return pq.QuoteIdentifier(cfg.TableSchema) + "." + pq.QuoteIdentifier(table)
+	}
+
+	return postgresTables{
+		migration: qualify(name),
+		event:     qualify(name + "_event"),
+		schema:    qualify(name + "_schema"),
+		script:    qualify(name + "_script"),
+		pk:        pq.QuoteIdentifier(name + "_pk"),
+		eventIdx:  pq.QuoteIdentifier(name + "_event_project_idx"),
+		scriptPk:  pq.QuoteIdentifier(name + "_script_pk"),
+	}
+}
+
+// query replaces table placeholders in the query: {migration}, {event},
+// {schema}, {script}, {pk}, {event_idx} and {script_pk}.
+func (t postgresTables) query(query string) string {
+	return strings.NewReplacer(
+		"{migration}", t.migration,
+		"{event}", t.event,
+		"{schema}", t.schema,
+		"{script}", t.script,
+		"{pk}", t.pk,
+		"{event_idx}", t.eventIdx,
+		"{script_pk}", t.scriptPk,
+	).Replace(query)
 }
 
 // Init opens a database specified by its database driver name and a
 // driver-specific data source name.
 func (p *PostgreSQL) Init(cfg *Config) error {
-	var err error
-	p.connect, err = sql.Open(TypePostgres, cfg.DSN)
+	if err := p.open(cfg); err != nil {
+		return err
+	}
 
-	if err != nil {
+	return p.upgrade(false)
+}
+
+// PreInit creates or upgrades migrago tables.
+func (p *PostgreSQL) PreInit(cfg *Config) error {
+	if err := p.open(cfg); err != nil {
 		return err
 	}
 
-	if cfg.Schema != "" {
-		if _, err := p.connect.Exec("SET search_path TO " + cfg.Schema); err != nil {
-			return err
+	return p.upgrade(true)
+}
+
+// open opens the connection pool. The schema is selected on every connection.
+func (p *PostgreSQL) open(cfg *Config) error {
+	var err error
+
+	p.tables = newPostgresTables(cfg)
+	p.connect, err = database.Open(TypePostgres, cfg.DSN, database.SchemaSession(TypePostgres, cfg.Schema))
+
+	return err
+}
+
+// postgresSchema contains steps of the storage schema. The step with index i
+// upgrades the schema to version i+1. Steps are never changed after a release,
+// new steps are appended. Statements are idempotent to upgrade tables created
+// before the schema was versioned. Table names are placeholders, see postgresTables.
+var postgresSchema = [][]string{
+	// 1: the table of the first release.
+	{
+		"CREATE TABLE IF NOT EXISTS {migration} (" +
+			"\"project\" varchar NOT NULL, \"database\" varchar NOT NULL,\"version\" varchar NOT NULL, " +
+			"\"apply_time\" bigint NOT NULL DEFAULT 0, \"rollback\" bool NOT NULL DEFAULT true, " +
+			"CONSTRAINT {pk} PRIMARY KEY (\"project\",\"database\",\"version\"))",
+	},
+	// 2: out-of-order migrations.
+	{
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"out_of_order\" bool NOT NULL DEFAULT false",
+	},
+	// 3: history details and failure state.
+	{
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"duration_ms\" bigint NOT NULL DEFAULT 0",
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"applied_by\" varchar NOT NULL DEFAULT ''",
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"host\" varchar NOT NULL DEFAULT ''",
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"tool_version\" varchar NOT NULL DEFAULT ''",
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"checksum\" varchar NOT NULL DEFAULT ''",
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"description\" varchar NOT NULL DEFAULT ''",
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"failed\" bool NOT NULL DEFAULT false",
+		"ALTER TABLE {migration} ADD COLUMN IF NOT EXISTS \"error\" text NOT NULL DEFAULT ''",
+	},
+	// 4: the history log.
+	{
+		"CREATE TABLE IF NOT EXISTS {event} (" +
+			"\"id\" bigserial PRIMARY KEY, \"time\" bigint NOT NULL, \"type\" varchar NOT NULL, " +
+			"\"project\" varchar NOT NULL, \"database\" varchar NOT NULL, \"version\" varchar NOT NULL DEFAULT '', " +
+			"\"actor\" varchar NOT NULL DEFAULT '', \"tool_version\" varchar NOT NULL DEFAULT '', " +
+			"\"description\" varchar NOT NULL DEFAULT '', \"duration_ms\" bigint NOT NULL DEFAULT 0, " +
+			"\"error\" text NOT NULL DEFAULT '')",
+		"CREATE INDEX IF NOT EXISTS {event_idx} ON {event} (\"project\", \"database\", \"time\")",
+	},
+	// 5: records of scripts.
+	{
+		"CREATE TABLE IF NOT EXISTS {script} (" +
+			"\"kind\" varchar NOT NULL, \"project\" varchar NOT NULL, \"database\" varchar NOT NULL, " +
+			"\"name\" varchar NOT NULL, \"apply_time\" bigint NOT NULL DEFAULT 0, " +
+			"\"checksum\" varchar NOT NULL DEFAULT '', \"duration_ms\" bigint NOT NULL DEFAULT 0, " +
+			"\"applied_by\" varchar NOT NULL DEFAULT '', \"host\" varchar NOT NULL DEFAULT '', " +
+			"\"tool_version\" varchar NOT NULL DEFAULT '', " +
+			"CONSTRAINT {script_pk} PRIMARY KEY (\"kind\", \"project\", \"database\", \"name\"))",
+	},
+}
+
+// postgresSchemaLock is a key of the advisory lock serializing schema upgrades.
+const postgresSchemaLock = 7306120601
+
+// postgresColumnList is a list of columns in the order of Migrate fields.
+const postgresColumnList = "project, database, version, apply_time, rollback, out_of_order, duration_ms, " +
+	"applied_by, host, tool_version, checksum, description, failed, error"
+
+// postgresScriptColumnList is a list of columns in the order of Script fields.
+const postgresScriptColumnList = "kind, project, database, name, apply_time, checksum, duration_ms, applied_by, " +
+	"host, tool_version"
+
+// SchemaVersion returns the version of the storage schema, 0 if it is not created.
+func (p *PostgreSQL) SchemaVersion() (int, error) {
+	const query = "SELECT to_regclass($1) IS NOT NULL"
+
+	var exists bool
+	if err := p.connect.QueryRow(query, p.tables.schema).Scan(&exists); err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		// A table created before the schema was versioned.
+		if err := p.connect.QueryRow(query, p.tables.migration).Scan(&exists); err != nil {
+			return 0, err
 		}
+
+		if exists {
+			return 1, nil
+		}
+
+		return 0, nil
 	}
 
-	return nil
+	var version int
+
+	row := p.connect.QueryRow(p.tables.query("SELECT COALESCE(MAX(version), 0) FROM {schema}"))
+	if err := row.Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
 }
 
-// PreInit creates migrago table.
-func (p *PostgreSQL) PreInit(cfg *Config) error {
-	var err error
+// upgrade applies pending steps of the storage schema. If create is false, a
+// storage which is not initialized yet is left as is.
+func (p *PostgreSQL) upgrade(create bool) error {
+	current, err := p.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("schema version: %w", err)
+	}
+
+	if current > len(postgresSchema) {
+		return fmt.Errorf("storage schema version %d is newer than supported %d, upgrade migrago",
+			current, len(postgresSchema))
+	}
+
+	if current == len(postgresSchema) || (current == 0 && !create) {
+		return nil
+	}
 
-	p.connect, err = sql.Open(TypePostgres, cfg.DSN)
+	txn, err := p.connect.Begin()
 	if err != nil {
-		return err
+		return fmt.Errorf("begin: %w", err)
 	}
 
-	if cfg.Schema != "" {
-		if _, err := p.connect.Exec("SET search_path TO " + cfg.Schema); err != nil {
-			return err
+	if err := p.upgradeTx(txn); err != nil {
+		if errRollback := txn.Rollback(); errRollback != nil {
+			return fmt.Errorf("rollback: %w", errRollback)
 		}
+
+		return fmt.Errorf("upgrade schema: %w", err)
 	}
 
-	if _, err := p.connect.Exec("CREATE TABLE migration (" +
-		"\"project\" varchar NOT NULL, \"database\" varchar NOT NULL,\"version\" varchar NOT NULL, " +
-		"\"apply_time\" bigint NOT NULL DEFAULT 0, \"rollback\" bool NOT NULL DEFAULT true, " +
-		"CONSTRAINT migration_pk PRIMARY KEY (\"project\",\"database\",\"version\"));"); err != nil {
+	return txn.Commit()
+}
+
+func (p *PostgreSQL) upgradeTx(txn *sql.Tx) error {
+	// Another migrago process may upgrade the schema at the same time.
+	if _, err := txn.Exec("SELECT pg_advisory_xact_lock($1)", postgresSchemaLock); err != nil {
 		return err
 	}
 
+	if _, err := txn.Exec(p.tables.query("CREATE TABLE IF NOT EXISTS {schema} (" +
+		"\"version\" int PRIMARY KEY, \"apply_time\" bigint NOT NULL)")); err != nil {
+		return err
+	}
+
+	var current int
+	query := p.tables.query("SELECT COALESCE(MAX(version), 0) FROM {schema}")
+	if err := txn.QueryRow(query).Scan(&current); err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= len(postgresSchema); version++ {
+		for _, query := range postgresSchema[version-1] {
+			if _, err := txn.Exec(p.tables.query(query)); err != nil {
+				return fmt.Errorf("version %d: %w", version, err)
+			}
+		}
+
+		if _, err := txn.Exec(p.tables.query("INSERT INTO {schema} (version, apply_time) VALUES ($1, $2)"),
+			version, time.Now().UTC().Unix()); err != nil {
+			return fmt.Errorf("version %d: %w", version, err)
+		}
+	}
+
 	return nil
 }
 
@@ -70,14 +276,14 @@ func (p *PostgreSQL) Close() error {
 
 // CreateProjectDB does nothing. Function is not needed for postgres.
 // Introduced to implement the Storage interface.
-func (*PostgreSQL) CreateProjectDB(string, string) error {
+func (*PostgreSQL) CreateProjectDB(context.Context, string, string) error {
 	return nil
 }
 
 // CheckMigration checks the migration was done successfully.
-func (p *PostgreSQL) CheckMigration(projectName, dbName, version string) (bool, error) {
-	row := p.connect.QueryRow(
-		"SELECT count(*) FROM migration WHERE project = $1 AND database = $2 AND version = $3 LIMIT 1",
+func (p *PostgreSQL) CheckMigration(ctx context.Context, projectName, dbName, version string) (bool, error) {
+	row := p.connect.QueryRowContext(ctx, p.tables.query(
+		"SELECT count(*) FROM {migration} WHERE project = $1 AND database = $2 AND version = $3 AND NOT failed LIMIT 1"),
 		projectName, dbName, version,
 	)
 
@@ -90,22 +296,55 @@ func (p *PostgreSQL) CheckMigration(projectName, dbName, version string) (bool,
 }
 
 // Up runs migration up.
-func (p *PostgreSQL) Up(post *Migrate) error {
-	if _, err := p.connect.Exec(
-		"INSERT INTO migration (project, database, version, apply_time, rollback) VALUES ($1, $2, $3, $4, $5)",
-		post.Project, post.Database, post.Version, post.ApplyTime, post.RollFlag,
-	); err != nil {
+func (p *PostgreSQL) Up(ctx context.Context, post *Migrate) error {
+	// A record of a failed attempt is replaced, a record of an applied migration is kept.
+	res, err := p.connect.ExecContext(ctx, p.tables.query(
+		"INSERT INTO {migration} AS m ("+postgresColumnList+") "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) "+
+			"ON CONFLICT (project, database, version) DO UPDATE SET apply_time = EXCLUDED.apply_time, "+
+			"rollback = EXCLUDED.rollback, out_of_order = EXCLUDED.out_of_order, duration_ms = EXCLUDED.duration_ms, "+
+			"applied_by = EXCLUDED.applied_by, host = EXCLUDED.host, tool_version = EXCLUDED.tool_version, "+
+			"checksum = EXCLUDED.checksum, description = EXCLUDED.description, failed = EXCLUDED.failed, "+
+			"error = EXCLUDED.error WHERE m.failed"),
+		post.Project, post.Database, post.Version, post.ApplyTime, post.RollFlag, post.OutOfOrder, post.DurationMs,
+		post.AppliedBy, post.Host, post.ToolVersion, post.Checksum, post.Description, post.Failed, post.Error,
+	)
+	if err != nil {
 		return err
 	}
 
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s %s: %w", post.Database, post.Version, ErrApplied)
+	}
+
 	return nil
 }
 
+// Replace saves the migration record, replacing any record of the version.
+func (p *PostgreSQL) Replace(ctx context.Context, post *Migrate) error {
+	_, err := p.connect.ExecContext(ctx, p.tables.query(
+		"INSERT INTO {migration} AS m ("+postgresColumnList+") "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) "+
+			"ON CONFLICT (project, database, version) DO UPDATE SET apply_time = EXCLUDED.apply_time, "+
+			"rollback = EXCLUDED.rollback, out_of_order = EXCLUDED.out_of_order, duration_ms = EXCLUDED.duration_ms, "+
+			"applied_by = EXCLUDED.applied_by, host = EXCLUDED.host, tool_version = EXCLUDED.tool_version, "+
+			"checksum = EXCLUDED.checksum, description = EXCLUDED.description, failed = EXCLUDED.failed, "+
+			"error = EXCLUDED.error"),
+		post.Project, post.Database, post.Version, post.ApplyTime, post.RollFlag, post.OutOfOrder, post.DurationMs,
+		post.AppliedBy, post.Host, post.ToolVersion, post.Checksum, post.Description, post.Failed, post.Error,
+	)
+
+	return err
+}
+
 // GetLast gets a list of recent migrations.
-func (p *PostgreSQL) GetLast(projectName, dbName string, skipNoRollback bool, limit *int) ([]Migrate, error) {
+func (p *PostgreSQL) GetLast(ctx context.Context, projectName, dbName string, skipNoRollback bool, limit *int) ([]Migrate, error) {
 	result := make([]Migrate, 0)
 
-	query := "SELECT project, database, version, apply_time, rollback FROM migration WHERE project = $1 AND database = $2"
+	query := p.tables.query("SELECT " + postgresColumnList +
+		" FROM {migration} WHERE project = $1 AND database = $2 AND NOT failed")
 
 	// Flag for skip non-rolling migrations.
 	if skipNoRollback {
@@ -118,7 +357,7 @@ func (p *PostgreSQL) GetLast(projectName, dbName string, skipNoRollback bool, li
 		query += " LIMIT " + strconv.Itoa(*limit)
 	}
 
-	rows, err := p.connect.Query(query, projectName, dbName)
+	rows, err := p.connect.QueryContext(ctx, query, projectName, dbName)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +365,9 @@ func (p *PostgreSQL) GetLast(projectName, dbName string, skipNoRollback bool, li
 
 	for rows.Next() {
 		var mi Migrate
-		if err := rows.Scan(&mi.Project, &mi.Database, &mi.Version, &mi.ApplyTime, &mi.RollFlag); err != nil {
+		if err := rows.Scan(&mi.Project, &mi.Database, &mi.Version, &mi.ApplyTime, &mi.RollFlag, &mi.OutOfOrder,
+			&mi.DurationMs, &mi.AppliedBy, &mi.Host, &mi.ToolVersion, &mi.Checksum, &mi.Description,
+			&mi.Failed, &mi.Error); err != nil {
 			continue
 		}
 
@@ -137,9 +378,9 @@ func (p *PostgreSQL) GetLast(projectName, dbName string, skipNoRollback bool, li
 }
 
 // Delete calls migration down.
-func (p *PostgreSQL) Delete(post *Migrate) error {
-	_, err := p.connect.Exec(
-		"DELETE FROM migration WHERE project = $1 AND database = $2 AND version = $3",
+func (p *PostgreSQL) Delete(ctx context.Context, post *Migrate) error {
+	_, err := p.connect.ExecContext(ctx, p.tables.query(
+		"DELETE FROM {migration} WHERE project = $1 AND database = $2 AND version = $3"),
 		post.Project, post.Database, post.Version,
 	)
 
@@ -147,3 +388,125 @@ func (p *PostgreSQL) Delete(post *Migrate) error {
 
 	return err
 }
+
+// GetAll returns records of all projects and databases, including failed ones.
+func (p *PostgreSQL) GetAll(ctx context.Context) ([]Migrate, error) {
+	rows, err := p.connect.QueryContext(ctx, p.tables.query(
+		"SELECT "+postgresColumnList+" FROM {migration} ORDER BY project, database, version"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Migrate{}
+
+	for rows.Next() {
+		var mi Migrate
+		if err := rows.Scan(&mi.Project, &mi.Database, &mi.Version, &mi.ApplyTime, &mi.RollFlag, &mi.OutOfOrder,
+			&mi.DurationMs, &mi.AppliedBy, &mi.Host, &mi.ToolVersion, &mi.Checksum, &mi.Description,
+			&mi.Failed, &mi.Error); err != nil {
+			return nil, err
+		}
+
+		result = append(result, mi)
+	}
+
+	return result, rows.Err()
+}
+
+// AddEvent appends the event to the history log.
+func (p *PostgreSQL) AddEvent(ctx context.Context, event *Event) error {
+	_, err := p.connect.ExecContext(ctx, p.tables.query(
+		"INSERT INTO {event} (time, type, project, database, version, actor, tool_version, description, "+
+			"duration_ms, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"),
+		event.Time, event.Type, event.Project, event.Database, event.Version, event.Actor, event.ToolVersion,
+		event.Description, event.DurationMs, event.Error,
+	)
+
+	return err
+}
+
+// GetEvents returns events matching the filter, oldest first.
+func (p *PostgreSQL) GetEvents(ctx context.Context, filter EventFilter) ([]Event, error) {
+	query := "SELECT time, type, project, database, version, actor, tool_version, description, duration_ms, error " +
+		"FROM {event} WHERE ($1 = '' OR project = $1) AND ($2 = '' OR database = $2) " +
+		"AND ($3 = '' OR version = $3) AND ($4 = 0 OR time >= $4) AND ($5 = 0 OR time <= $5) ORDER BY id DESC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(filter.Limit)
+	}
+
+	rows, err := p.connect.QueryContext(ctx, p.tables.query(query),
+		filter.Project, filter.Database, filter.Version, filter.Since, filter.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.Time, &e.Type, &e.Project, &e.Database, &e.Version, &e.Actor, &e.ToolVersion,
+			&e.Description, &e.DurationMs, &e.Error); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	// Events are selected newest first to apply the limit.
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	return events, rows.Err()
+}
+
+// GetScripts returns applied scripts of the kind, sorted by name.
+func (p *PostgreSQL) GetScripts(ctx context.Context, kind, projectName, dbName string) ([]Script, error) {
+	return p.getScripts(ctx, "SELECT "+postgresScriptColumnList+" FROM {script} "+
+		"WHERE kind = $1 AND project = $2 AND database = $3 ORDER BY name", kind, projectName, dbName)
+}
+
+// GetAllScripts returns applied scripts of all kinds, projects and databases.
+func (p *PostgreSQL) GetAllScripts(ctx context.Context) ([]Script, error) {
+	return p.getScripts(ctx, "SELECT "+postgresScriptColumnList+" FROM {script} "+
+		"ORDER BY kind, project, database, name")
+}
+
+func (p *PostgreSQL) getScripts(ctx context.Context, query string, args ...interface{}) ([]Script, error) {
+	rows, err := p.connect.QueryContext(ctx, p.tables.query(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scripts := []Script{}
+
+	for rows.Next() {
+		var s Script
+		if err := rows.Scan(&s.Kind, &s.Project, &s.Database, &s.Name, &s.ApplyTime, &s.Checksum, &s.DurationMs,
+			&s.AppliedBy, &s.Host, &s.ToolVersion); err != nil {
+			return nil, err
+		}
+
+		scripts = append(scripts, s)
+	}
+
+	return scripts, rows.Err()
+}
+
+// SaveScript saves the applied script, replacing its previous record.
+func (p *PostgreSQL) SaveScript(ctx context.Context, script *Script) error {
+	_, err := p.connect.ExecContext(ctx, p.tables.query(
+		"INSERT INTO {script} ("+postgresScriptColumnList+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) "+
+			"ON CONFLICT (kind, project, database, name) DO UPDATE SET apply_time = EXCLUDED.apply_time, "+
+			"checksum = EXCLUDED.checksum, duration_ms = EXCLUDED.duration_ms, applied_by = EXCLUDED.applied_by, "+
+			"host = EXCLUDED.host, tool_version = EXCLUDED.tool_version"),
+		script.Kind, script.Project, script.Database, script.Name, script.ApplyTime, script.Checksum,
+		script.DurationMs, script.AppliedBy, script.Host, script.ToolVersion,
+	)
+
+	return err
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// serialStorage serializes access to a storage which does not support concurrent writers.
+type serialStorage struct {
+	mu sync.Mutex
+	s  Storage
+}
+
+// Serialize returns the storage safe for concurrent use. Storages which
+// support concurrent writers are returned as is.
+func Serialize(s Storage) Storage {
+	switch s.(type) {
+	case *PostgreSQL, *serialStorage:
+		return s
+	default:
+		return &serialStorage{s: s}
+	}
+}
+
+func (s *serialStorage) PreInit(cfg *Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.PreInit(cfg)
+}
+
+func (s *serialStorage) Init(cfg *Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.Init(cfg)
+}
+
+func (s *serialStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.Close()
+}
+
+func (s *serialStorage) CreateProjectDB(ctx context.Context, projectName, dbName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.CreateProjectDB(ctx, projectName, dbName)
+}
+
+func (s *serialStorage) CheckMigration(ctx context.Context, projectName, dbName, version string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.CheckMigration(ctx, projectName, dbName, version)
+}
+
+func (s *serialStorage) Up(ctx context.Context, post *Migrate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.Up(ctx, post)
+}
+
+func (s *serialStorage) Replace(ctx context.Context, post *Migrate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.Replace(ctx, post)
+}
+
+func (s *serialStorage) GetLast(ctx context.Context, projectName, dbName string, skipNoRollback bool,
+	limit *int) ([]Migrate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.GetLast(ctx, projectName, dbName, skipNoRollback, limit)
+}
+
+func (s *serialStorage) Delete(ctx context.Context, post *Migrate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.Delete(ctx, post)
+}
+
+func (s *serialStorage) GetAll(ctx context.Context) ([]Migrate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.GetAll(ctx)
+}
+
+func (s *serialStorage) AddEvent(ctx context.Context, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.AddEvent(ctx, event)
+}
+
+func (s *serialStorage) GetEvents(ctx context.Context, filter EventFilter) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.GetEvents(ctx, filter)
+}
+
+func (s *serialStorage) GetScripts(ctx context.Context, kind, projectName, dbName string) ([]Script, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.GetScripts(ctx, kind, projectName, dbName)
+}
+
+func (s *serialStorage) GetAllScripts(ctx context.Context) ([]Script, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.GetAllScripts(ctx)
+}
+
+func (s *serialStorage) SaveScript(ctx context.Context, script *Script) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.SaveScript(ctx, script)
+}
@@ -1,30 +1,59 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
 
-	"gopkg.in/yaml.v2"
+	"github.com/librun/migrago/internal/config"
 )
 
+// ErrApplied is returned by Up if the migration has a record of a successful application.
+var ErrApplied = errors.New("migration is already applied")
+
 // Allowed database storage types.
 const (
 	TypeBoltDB   = "boltdb"
 	TypePostgres = "postgres"
 )
 
+// Kinds of scripts.
+const (
+	// ScriptRepeatable is a repeatable migration, it is applied again when its checksum changes.
+	ScriptRepeatable = "repeatable"
+	// ScriptSeed is a seed of data, it is applied again when its checksum changes.
+	ScriptSeed = "seed"
+)
+
 type (
 	// Storage describes methods for working with a migration storage.
 	Storage interface {
 		PreInit(cfg *Config) error
 		Init(cfg *Config) error
 		Close() error
-		CreateProjectDB(projectName, dbName string) error
-		CheckMigration(projectName, dbName, version string) (bool, error)
-		Up(post *Migrate) error
-		GetLast(projectName, dbName string, skipNoRollback bool, limit *int) ([]Migrate, error)
-		Delete(post *Migrate) error
+		CreateProjectDB(ctx context.Context, projectName, dbName string) error
+		// CheckMigration checks that the migration is applied (failed migrations are not applied).
+		CheckMigration(ctx context.Context, projectName, dbName, version string) (bool, error)
+		// Up saves the migration record, replacing a record of a failed attempt.
+		// ErrApplied is returned if the migration has a record of a successful one.
+		Up(ctx context.Context, post *Migrate) error
+		// Replace saves the migration record in one transaction, replacing any record of the version.
+		Replace(ctx context.Context, post *Migrate) error
+		// GetLast returns applied migrations, newest first.
+		GetLast(ctx context.Context, projectName, dbName string, skipNoRollback bool, limit *int) ([]Migrate, error)
+		Delete(ctx context.Context, post *Migrate) error
+		// GetAll returns records of all projects and databases, including failed ones.
+		GetAll(ctx context.Context) ([]Migrate, error)
+		// AddEvent appends the event to the history log.
+		AddEvent(ctx context.Context, event *Event) error
+		// GetEvents returns events matching the filter, oldest first.
+		GetEvents(ctx context.Context, filter EventFilter) ([]Event, error)
+		// GetScripts returns applied scripts of the kind, sorted by name.
+		GetScripts(ctx context.Context, kind, projectName, dbName string) ([]Script, error)
+		// GetAllScripts returns applied scripts of all kinds, projects and databases.
+		GetAllScripts(ctx context.Context) ([]Script, error)
+		// SaveScript saves the applied script, replacing its previous record.
+		SaveScript(ctx context.Context, script *Script) error
 	}
 
 	// Config contains storage credentials information.
@@ -33,6 +62,11 @@ type (
 		Path        string `yaml:"path"`
 		DSN         string `yaml:"dsn"`
 		Schema      string `yaml:"schema"`
+		// Table and TableSchema set the name and the schema of the migration table
+		// for sql storages. The history log and the schema version tables are
+		// named after it.
+		Table       string `yaml:"table"`
+		TableSchema string `yaml:"table_schema"`
 	}
 
 	configFull struct {
@@ -41,11 +75,45 @@ type (
 
 	// Migrate is the model for table migration.
 	Migrate struct {
-		Project   string
-		Database  string
-		Version   string
-		ApplyTime int64
-		RollFlag  bool
+		Project   string `json:"project"`
+		Database  string `json:"database"`
+		Version   string `json:"version"`
+		ApplyTime int64  `json:"apply_time"`
+		RollFlag  bool   `json:"roll_flag"`
+		// OutOfOrder is true if the migration was applied after a newer one.
+		OutOfOrder bool `json:"out_of_order"`
+		// DurationMs is the execution time of the migration in milliseconds.
+		DurationMs int64 `json:"duration_ms"`
+		// AppliedBy and Host are the OS user and the hostname which applied the migration.
+		AppliedBy string `json:"applied_by"`
+		Host      string `json:"host"`
+		// ToolVersion is the migrago version which applied the migration.
+		ToolVersion string `json:"tool_version"`
+		// Checksum is the SHA-256 of the up migration.
+		Checksum string `json:"checksum"`
+		// Description is a free-form deploy description from the command line.
+		Description string `json:"description"`
+		// Failed is true if the migration failed, Error contains the failure reason.
+		// Failed migrations are not considered applied.
+		Failed bool   `json:"failed"`
+		Error  string `json:"error"`
+	}
+
+	// Script is the record of an applied script which has no version, such
+	// as a repeatable migration. Scripts are identified by their names.
+	Script struct {
+		Kind      string `json:"kind"`
+		Project   string `json:"project"`
+		Database  string `json:"database"`
+		Name      string `json:"name"`
+		ApplyTime int64  `json:"apply_time"`
+		// Checksum is the SHA-256 of the applied script.
+		Checksum   string `json:"checksum"`
+		DurationMs int64  `json:"duration_ms"`
+		AppliedBy  string `json:"applied_by"`
+		Host       string `json:"host"`
+		// ToolVersion is the migrago version which applied the script.
+		ToolVersion string `json:"tool_version"`
 	}
 )
 
@@ -84,24 +152,37 @@ func PreInit(pathConfigFile string) error {
 	return nil
 }
 
-// parseConfig gets and returns the part of the config associated
-// with Migrago storage.
-func parseConfig(path string) (*Config, error) {
-	configFile, err := os.Open(path)
+// Relations returns names of tables and sequences of a sql storage, they are
+// qualified by the schema if the table schema is set. A boltdb storage has none.
+func Relations(pathConfigFile string) ([]string, error) {
+	cfg, err := parseConfig(pathConfigFile)
 	if err != nil {
-		return nil, fmt.Errorf("config open: %w", err)
+		return nil, fmt.Errorf("storage parse: %w", err)
 	}
-	defer configFile.Close()
 
-	configByte, err := ioutil.ReadAll(configFile)
-	if err != nil {
-		return nil, fmt.Errorf("config read: %w", err)
+	if cfg.StorageType != TypePostgres {
+		return nil, nil
 	}
 
+	name := cfg.Table
+	if name == "" {
+		name = postgresTableDefault
+	}
+
+	if cfg.TableSchema != "" {
+		name = cfg.TableSchema + "." + name
+	}
+
+	return []string{name, name + "_event", name + "_event_id_seq", name + "_schema", name + "_script"}, nil
+}
+
+// parseConfig gets and returns the part of the config associated
+// with Migrago storage.
+func parseConfig(path string) (*Config, error) {
 	cfg := configFull{}
 
-	if err := yaml.Unmarshal(configByte, &cfg); err != nil {
-		return nil, fmt.Errorf("config format: %w", err)
+	if err := config.Decode(path, &cfg); err != nil {
+		return nil, err
 	}
 
 	return &cfg.MigrationStorage, nil
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"os/user"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/librun/migrago/internal/action"
+	"github.com/librun/migrago/internal/output"
 	"github.com/librun/migrago/internal/storage"
 	"github.com/urfave/cli"
 )
@@ -21,14 +29,38 @@ func main() {
 	app.Version = Version
 	app.Usage = "cli-migration"
 	app.Flags = []cli.Flag{
-		cli.StringFlag{Name: "config, c", Usage: "Path to configuration file", Required: true},
+		cli.StringFlag{
+			Name:   "config, c",
+			Usage:  "Path to configuration file (yaml, json or toml), \"-\" to read from stdin",
+			EnvVar: "MIGRAGO_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "output",
+			Usage:  "Format of command results on stdout: text, json or table (logs are written to stderr)",
+			EnvVar: "MIGRAGO_OUTPUT",
+			Value:  output.FormatText,
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		return output.Check(c.GlobalString("output"))
 	}
 	app.Commands = []cli.Command{
 		getCommandUp(),
 		getCommandDown(),
+		getCommandSeed(),
 		getCommandList(),
 		getCommandInit(),
 		getCommandCreate(),
+		getCommandRenumber(),
+		getCommandSquash(),
+		getCommandMark(),
+		getCommandUnmark(),
+		getCommandHistory(),
+		getCommandTransfer(),
+		getCommandExport(),
+		getCommandImport(),
+		getCommandTest(),
+		getCommandSchema(),
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -45,8 +77,23 @@ func getCommandUp() cli.Command {
 		Flags: []cli.Flag{
 			cli.StringFlag{Name: "project, p", Usage: "Project name"},
 			cli.StringFlag{Name: "database, db, d", Usage: "Database name"},
+			cli.BoolFlag{Name: "allow-out-of-order", Usage: "Apply migrations older than the last applied one"},
+			cli.StringFlag{Name: "description", Usage: "Deploy description saved in migration history"},
+			cli.DurationFlag{Name: "timeout", Usage: "Timeout of the whole run (for example 10m)"},
+			cli.DurationFlag{Name: "migration-timeout", Usage: "Timeout of each migration (for example 1m)"},
+			cli.IntFlag{Name: "parallel", Usage: "Number of databases migrated concurrently", Value: 1},
+			cli.StringFlag{Name: "tenant", Usage: "Migrate only tenants matching the glob pattern"},
+			cli.StringSliceFlag{Name: "report", Usage: "Write a report file for CI: junit=<file> or tap=<file>"},
 		},
 		Action: func(c *cli.Context) error {
+			reports, err := reportFiles(c)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := commandContext(c)
+			defer cancel()
+
 			mStorage, err := storage.New(c.GlobalString("config"))
 			if err != nil {
 				return err
@@ -57,18 +104,26 @@ func getCommandUp() cli.Command {
 				}
 			}()
 
-			var project *string
-			var database *string
+			opts := action.UpOptions{
+				AllowOutOfOrder:  c.Bool("allow-out-of-order"),
+				ToolVersion:      Version,
+				Description:      c.String("description"),
+				MigrationTimeout: c.Duration("migration-timeout"),
+				Parallel:         c.Int("parallel"),
+				Tenant:           c.String("tenant"),
+			}
 			if c.IsSet("project") {
 				p := c.String("project")
-				project = &p
+				opts.Project = &p
 			}
 			if c.IsSet("database") {
 				d := c.String("database")
-				database = &d
+				opts.Database = &d
 			}
 
-			if err := action.MakeUp(mStorage, c.GlobalString("config"), project, database); err != nil {
+			report, err := action.MakeUp(ctx, mStorage, c.GlobalString("config"), opts)
+			err = writeReports(reports, report, "migrago up", err)
+			if err := writeResult(c, report, err); err != nil {
 				return err
 			}
 
@@ -90,8 +145,19 @@ func getCommandDown() cli.Command {
 			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: true},
 			cli.IntFlag{Name: "limit, l", Usage: "Limit revert migrations", Required: true, Value: 1},
 			cli.BoolFlag{Name: "no-skip", Usage: "Not skip migration with rollback is false"},
+			cli.DurationFlag{Name: "timeout", Usage: "Timeout of the whole run (for example 10m)"},
+			cli.DurationFlag{Name: "migration-timeout", Usage: "Timeout of each migration (for example 1m)"},
+			cli.StringSliceFlag{Name: "report", Usage: "Write a report file for CI: junit=<file> or tap=<file>"},
 		},
 		Action: func(c *cli.Context) error {
+			reports, err := reportFiles(c)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := commandContext(c)
+			defer cancel()
+
 			mStorage, err := storage.New(c.GlobalString("config"))
 			if err != nil {
 				return err
@@ -123,7 +189,18 @@ func getCommandDown() cli.Command {
 				skip = false
 			}
 
-			if err := action.MakeDown(mStorage, c.GlobalString("config"), project, db, rollbackCount, skip); err != nil {
+			opts := action.DownOptions{
+				Project:          project,
+				Database:         db,
+				Limit:            rollbackCount,
+				SkipNoRollback:   skip,
+				ToolVersion:      Version,
+				MigrationTimeout: c.Duration("migration-timeout"),
+			}
+
+			report, err := action.MakeDown(ctx, mStorage, c.GlobalString("config"), opts)
+			err = writeReports(reports, report, "migrago down", err)
+			if err := writeResult(c, report, err); err != nil {
 				return fmt.Errorf("down: %w", err)
 			}
 
@@ -145,8 +222,13 @@ func getCommandList() cli.Command {
 			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: true},
 			cli.IntFlag{Name: "limit, l", Usage: "Limit revert migrations"},
 			cli.BoolFlag{Name: "no-skip", Usage: "Not skip migration with rollback is false"},
+			cli.BoolFlag{Name: "verbose, v", Usage: "Show history details of migrations"},
+			cli.StringFlag{Name: "tenant", Usage: "Show only tenants matching the glob pattern"},
 		},
 		Action: func(c *cli.Context) error {
+			ctx, cancel := commandContext(c)
+			defer cancel()
+
 			mStorage, err := storage.New(c.GlobalString("config"))
 			if err != nil {
 				return err
@@ -182,7 +264,9 @@ func getCommandList() cli.Command {
 				skip = false
 			}
 
-			if err := action.MakeList(mStorage, c.GlobalString("config"), project, db, rollbackCount, skip); err != nil {
+			list, err := action.MakeList(ctx, mStorage, c.GlobalString("config"), project, db, c.String("tenant"),
+				rollbackCount, skip, c.Bool("verbose"))
+			if err := writeResult(c, list, err); err != nil {
 				log.Fatalln(err)
 			}
 
@@ -202,6 +286,10 @@ func getCommandInit() cli.Command {
 				return err
 			}
 
+			if err := writeResult(c, output.Status{Status: "initialized"}, nil); err != nil {
+				return err
+			}
+
 			log.Println("init storage is successfully")
 
 			return nil
@@ -219,7 +307,8 @@ func getCommandCreate() cli.Command {
 			cli.StringFlag{Name: "project, p", Usage: "Project name", Required: true},
 			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: false},
 			cli.StringFlag{Name: "name, n", Usage: "File name", Required: false},
-			cli.StringFlag{Name: "mode, m", Usage: "Migration file type [up|down|both] (default: up)", Required: false},
+			cli.StringFlag{Name: "mode, m", Usage: "Migration file type [up|down|both|single] (default: both)", Required: false},
+			cli.StringFlag{Name: "author, a", Usage: "Migration author for templates (default: current OS user)", Required: false},
 		},
 		Action: func(c *cli.Context) error {
 			project := c.String("project")
@@ -237,16 +326,22 @@ func getCommandCreate() cli.Command {
 				return errors.New("migration name required")
 			}
 
+			// An empty mode is resolved by the project naming.
 			mode := c.String("mode")
-			if mode == "" {
-				mode = action.CreateModeBoth
+			if mode != "" && mode != action.CreateModeUp && mode != action.CreateModeDown &&
+				mode != action.CreateModeBoth && mode != action.CreateModeSingle {
+				return fmt.Errorf("invalid mode: %s", mode)
 			}
 
-			if mode != action.CreateModeUp && mode != action.CreateModeDown && mode != action.CreateModeBoth {
-				return fmt.Errorf("invalid mode: %s", mode)
+			author := c.String("author")
+			if author == "" {
+				if u, err := user.Current(); err == nil {
+					author = u.Username
+				}
 			}
 
-			if err := action.MakeCreate(c.GlobalString("config"), name, mode, project, db); err != nil {
+			created, err := action.MakeCreate(c.GlobalString("config"), name, mode, project, db, author)
+			if err := writeResult(c, created, err); err != nil {
 				log.Fatalln(err)
 			}
 
@@ -256,3 +351,509 @@ func getCommandCreate() cli.Command {
 		},
 	}
 }
+
+func getCommandRenumber() cli.Command {
+	return cli.Command{
+		Name:        "renumber",
+		Usage:       "Renumber unapplied sequence migrations",
+		Description: "Resolve duplicate or out-of-order sequence numbers in unapplied migrations after a merge",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "project, p", Usage: "Project name", Required: true},
+			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: true},
+			cli.BoolFlag{Name: "dry-run", Usage: "Show renames without renaming files"},
+		},
+		Action: func(c *cli.Context) error {
+			ctx, cancel := commandContext(c)
+			defer cancel()
+
+			mStorage, err := storage.New(c.GlobalString("config"))
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := mStorage.Close(); err != nil {
+					log.Println(err)
+				}
+			}()
+
+			project := c.String("project")
+			if project == "" {
+				return errors.New("project required")
+			}
+
+			db := c.String("db")
+			if db == "" {
+				return errors.New("database required")
+			}
+
+			renumber, err := action.MakeRenumber(ctx, mStorage, c.GlobalString("config"), project, db, c.Bool("dry-run"))
+			if err := writeResult(c, renumber, err); err != nil {
+				return fmt.Errorf("renumber: %w", err)
+			}
+
+			log.Println("Renumber is successfully")
+
+			return nil
+		},
+	}
+}
+
+func getCommandSquash() cli.Command {
+	return cli.Command{
+		Name:        "squash",
+		Usage:       "Squash old migrations into a baseline",
+		Description: "Replace migrations up to the version by one baseline migration, new databases apply the baseline only",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "project, p", Usage: "Project name", Required: true},
+			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: true},
+			cli.StringFlag{Name: "to", Usage: "Newest squashed migration, it becomes the baseline", Required: true},
+			cli.BoolFlag{Name: "from-schema", Usage: "Make the baseline from the schema dump of the database"},
+			cli.BoolFlag{Name: "dry-run", Usage: "Show squashed migrations without changing files and storage"},
+		},
+		Action: func(c *cli.Context) error {
+			opts := action.SquashOptions{
+				Version:     c.String("to"),
+				FromSchema:  c.Bool("from-schema"),
+				DryRun:      c.Bool("dry-run"),
+				ToolVersion: Version,
+			}
+
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				squash, err := action.MakeSquash(ctx, mStorage, c.GlobalString("config"), c.String("project"),
+					c.String("db"), opts)
+
+				return writeResult(c, squash, err)
+			})
+		},
+	}
+}
+
+func getCommandMark() cli.Command {
+	return cli.Command{
+		Name:        "mark",
+		Usage:       "Mark migration as applied",
+		Description: "Record migration as applied without executing it",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "project, p", Usage: "Project name", Required: true},
+			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: true},
+			cli.StringFlag{Name: "version, V", Usage: "Migration version", Required: true},
+		},
+		Action: func(c *cli.Context) error {
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				history, err := action.MakeMark(ctx, mStorage, c.GlobalString("config"), c.String("project"),
+					c.String("db"), c.String("version"), Version)
+
+				return writeResult(c, history, err)
+			})
+		},
+	}
+}
+
+func getCommandUnmark() cli.Command {
+	return cli.Command{
+		Name:        "unmark",
+		Usage:       "Unmark applied migration",
+		Description: "Remove migration record without reverting it",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "project, p", Usage: "Project name", Required: true},
+			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: true},
+			cli.StringFlag{Name: "version, V", Usage: "Migration version", Required: true},
+		},
+		Action: func(c *cli.Context) error {
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				history, err := action.MakeUnmark(ctx, mStorage, c.GlobalString("config"), c.String("project"),
+					c.String("db"), c.String("version"), Version)
+
+				return writeResult(c, history, err)
+			})
+		},
+	}
+}
+
+func getCommandHistory() cli.Command {
+	return cli.Command{
+		Name:        "history",
+		Usage:       "Show migrations history",
+		Description: "Show the log of applied, reverted, marked, unmarked and failed migrations",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "project, p", Usage: "Project name"},
+			cli.StringFlag{Name: "database, db, d", Usage: "Database name"},
+			cli.StringFlag{Name: "version, V", Usage: "Migration version"},
+			cli.StringFlag{Name: "since", Usage: "Show events since time (RFC3339 or YYYY-MM-DD)"},
+			cli.StringFlag{Name: "until", Usage: "Show events until time (RFC3339 or YYYY-MM-DD)"},
+			cli.IntFlag{Name: "limit, l", Usage: "Show only the newest events"},
+		},
+		Action: func(c *cli.Context) error {
+			filter := storage.EventFilter{
+				Project:  c.String("project"),
+				Database: c.String("db"),
+				Version:  c.String("version"),
+				Limit:    c.Int("limit"),
+			}
+
+			var err error
+
+			if filter.Since, err = parseTime(c.String("since")); err != nil {
+				return fmt.Errorf("since: %w", err)
+			}
+
+			if filter.Until, err = parseTime(c.String("until")); err != nil {
+				return fmt.Errorf("until: %w", err)
+			}
+
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				history, err := action.MakeHistory(ctx, mStorage, filter)
+
+				return writeResult(c, history, err)
+			})
+		},
+	}
+}
+
+func getCommandTransfer() cli.Command {
+	return cli.Command{
+		Name:        "transfer",
+		Usage:       "Transfer migrations history to another storage",
+		Description: "Copy migration records and the history log of all projects and databases to the storage of another config",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "to", Usage: "Path to configuration file of the destination storage", Required: true},
+			cli.BoolFlag{Name: "dry-run", Usage: "Show records to transfer and conflicts without writing"},
+		},
+		Action: func(c *cli.Context) error {
+			dst, err := storage.New(c.String("to"))
+			if err != nil {
+				return fmt.Errorf("destination: %w", err)
+			}
+			defer func() {
+				if err := dst.Close(); err != nil {
+					log.Println(err)
+				}
+			}()
+
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				report, err := action.MakeTransfer(ctx, mStorage, dst, c.Bool("dry-run"))
+
+				return writeResult(c, report, err)
+			})
+		},
+	}
+}
+
+func getCommandExport() cli.Command {
+	return cli.Command{
+		Name:        "export",
+		Usage:       "Export migrations history to JSON",
+		Description: "Write migration records and the history log of all projects and databases to a JSON file",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "file, f", Usage: "Path to the output file, \"-\" for stdout", Value: "-"},
+		},
+		Action: func(c *cli.Context) error {
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				report, err := action.MakeExport(ctx, mStorage, c.String("file"))
+
+				// The history itself is written to stdout.
+				if c.String("file") == "-" {
+					return err
+				}
+
+				return writeResult(c, report, err)
+			})
+		},
+	}
+}
+
+func getCommandImport() cli.Command {
+	return cli.Command{
+		Name:        "import",
+		Usage:       "Import migrations history from JSON",
+		Description: "Load migration records and the history log written by the export command",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "input, i", Usage: "Path to the input file, \"-\" for stdin", Required: true},
+			cli.BoolFlag{Name: "dry-run", Usage: "Show records to import and conflicts without writing"},
+		},
+		Action: func(c *cli.Context) error {
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				report, err := action.MakeImport(ctx, mStorage, c.String("input"), c.Bool("dry-run"))
+
+				return writeResult(c, report, err)
+			})
+		},
+	}
+}
+
+// writeResult writes the command result to stdout in the output format and
+// returns the command error. A partial result of a failed command (a report of
+// an interrupted run) is written too, an empty one is skipped.
+func writeResult(c *cli.Context, result output.Table, err error) error {
+	if err != nil && reflect.ValueOf(result).IsZero() {
+		return err
+	}
+
+	if errWrite := output.Write(os.Stdout, c.GlobalString("output"), result); errWrite != nil && err == nil {
+		return errWrite
+	}
+
+	return err
+}
+
+func getCommandTest() cli.Command {
+	return cli.Command{
+		Name:        "test",
+		Usage:       "Test migrations round trip on a scratch database",
+		Description: "Apply each migration to a scratch database, revert it and apply it again, the storage is not changed",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "project, p", Usage: "Project name", Required: true},
+			cli.StringFlag{Name: "database, db, d", Usage: "Database name", Required: true},
+			cli.StringFlag{Name: "dsn", Usage: "DSN of the scratch database", Required: true},
+			cli.StringFlag{Name: "schema", Usage: "Schema of the scratch database (default: schema of the database)"},
+			cli.BoolFlag{Name: "all", Usage: "Test all migrations, not only unapplied ones"},
+			cli.BoolFlag{Name: "compare-schema", Usage: "Check that down migrations restore the schema"},
+			cli.DurationFlag{Name: "timeout", Usage: "Timeout of the whole run (for example 10m)"},
+			cli.DurationFlag{Name: "migration-timeout", Usage: "Timeout of each migration (for example 1m)"},
+			cli.StringSliceFlag{Name: "report", Usage: "Write a report file for CI: junit=<file> or tap=<file>"},
+		},
+		Action: func(c *cli.Context) error {
+			reports, err := reportFiles(c)
+			if err != nil {
+				return err
+			}
+
+			opts := action.TestOptions{
+				Project:          c.String("project"),
+				Database:         c.String("db"),
+				DSN:              c.String("dsn"),
+				Schema:           c.String("schema"),
+				All:              c.Bool("all"),
+				CompareSchema:    c.Bool("compare-schema"),
+				MigrationTimeout: c.Duration("migration-timeout"),
+			}
+
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				report, err := action.MakeTest(ctx, mStorage, c.GlobalString("config"), opts)
+				err = writeReports(reports, report, "migrago test", err)
+
+				return writeResult(c, report, err)
+			})
+		},
+	}
+}
+
+func getCommandSchema() cli.Command {
+	flags := []cli.Flag{
+		cli.StringFlag{Name: "database, db, d", Usage: "Database name, \"database/tenant\" for a tenant", Required: true},
+		cli.StringFlag{Name: "file, f", Usage: "Path to the schema file (default: schema_file of the database)"},
+		cli.DurationFlag{Name: "timeout", Usage: "Timeout of the command (for example 1m)"},
+	}
+
+	return cli.Command{
+		Name:        "schema",
+		Usage:       "Dump the database schema or compare it with the schema file",
+		Description: "Keep a normalized DDL snapshot of the database schema and check it for drift",
+		Subcommands: []cli.Command{
+			{
+				Name:        "dump",
+				Usage:       "Write the database schema to the schema file",
+				Description: "Write DDL of tables, constraints, indexes and views of the database, \"-\" file for stdout",
+				Flags:       flags,
+				Action: func(c *cli.Context) error {
+					ctx, cancel := commandContext(c)
+					defer cancel()
+
+					result, err := action.MakeSchemaDump(ctx, c.GlobalString("config"), c.String("db"), c.String("file"))
+
+					// The schema itself is written to stdout.
+					if c.String("file") == "-" {
+						return err
+					}
+
+					return writeResult(c, result, err)
+				},
+			},
+			{
+				Name:        "diff",
+				Usage:       "Compare the database schema with the schema file",
+				Description: "Show statements changed outside of migrations, fail if the schema drifted",
+				Flags:       flags,
+				Action: func(c *cli.Context) error {
+					ctx, cancel := commandContext(c)
+					defer cancel()
+
+					result, err := action.MakeSchemaDiff(ctx, c.GlobalString("config"), c.String("db"), c.String("file"))
+
+					return writeResult(c, result, err)
+				},
+			},
+		},
+	}
+}
+
+func getCommandSeed() cli.Command {
+	return cli.Command{
+		Name:        "seed",
+		Usage:       "Apply seed data",
+		Description: "Apply new and changed seeds of projects, seeds of the environment are applied in addition to common ones",
+		ArgsUsage:   "",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "project, p", Usage: "Project name"},
+			cli.StringFlag{Name: "database, db, d", Usage: "Database name"},
+			cli.StringFlag{Name: "env, e", Usage: "Environment, selects seeds of its subdirectory", EnvVar: "MIGRAGO_ENV"},
+			cli.StringFlag{Name: "tenant", Usage: "Seed only tenants matching the glob pattern"},
+			cli.StringFlag{Name: "description", Usage: "Description saved in seed history"},
+			cli.DurationFlag{Name: "timeout", Usage: "Timeout of the whole run (for example 10m)"},
+			cli.DurationFlag{Name: "migration-timeout", Usage: "Timeout of each seed (for example 1m)"},
+			cli.StringSliceFlag{Name: "report", Usage: "Write a report file for CI: junit=<file> or tap=<file>"},
+		},
+		Action: func(c *cli.Context) error {
+			reports, err := reportFiles(c)
+			if err != nil {
+				return err
+			}
+
+			opts := action.SeedOptions{
+				Project:          c.String("project"),
+				Database:         c.String("db"),
+				Env:              c.String("env"),
+				Tenant:           c.String("tenant"),
+				ToolVersion:      Version,
+				Description:      c.String("description"),
+				MigrationTimeout: c.Duration("migration-timeout"),
+			}
+
+			return withStorage(c, func(ctx context.Context, mStorage storage.Storage) error {
+				report, err := action.MakeSeed(ctx, mStorage, c.GlobalString("config"), opts)
+				err = writeReports(reports, report, "migrago seed", err)
+
+				return writeResult(c, report, err)
+			})
+		},
+	}
+}
+
+// reportFiles parses report files of the command.
+func reportFiles(c *cli.Context) ([]action.ReportFile, error) {
+	files := make([]action.ReportFile, 0, len(c.StringSlice("report")))
+
+	for _, option := range c.StringSlice("report") {
+		file, err := action.ParseReportFile(option)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// writeReports writes the report of the run to the report files and returns
+// the run error. The report of a failed run is written too, unless it is empty.
+func writeReports(files []action.ReportFile, report action.Report, name string, err error) error {
+	if err != nil && len(report.Databases) == 0 {
+		return err
+	}
+
+	for _, file := range files {
+		if errWrite := report.WriteFile(file, name); errWrite != nil {
+			if err == nil {
+				return errWrite
+			}
+
+			log.Println(errWrite)
+		}
+	}
+
+	return err
+}
+
+// withStorage opens the migration storage for the command and closes it after f.
+func withStorage(c *cli.Context, f func(ctx context.Context, mStorage storage.Storage) error) error {
+	ctx, cancel := commandContext(c)
+	defer cancel()
+
+	mStorage, err := storage.New(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := mStorage.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	return f(ctx, mStorage)
+}
+
+// commandContext returns a context of the command, which is cancelled by
+// SIGINT or SIGTERM and after the timeout flag of the command. The second
+// signal terminates the process immediately.
+func commandContext(c *cli.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if timeout := c.Duration("timeout"); timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+
+		cancelParent := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelParent()
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// The goroutine exits when the returned cancel function is called.
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("%s received, cancelling (repeat to exit immediately)", sig)
+			cancel()
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+
+		select {
+		case sig := <-signals:
+			log.Fatalf("%s received, exit", sig)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			cancel()
+		})
+	}
+}
+
+// parseTime parses RFC3339 time or a date to unix time. An empty value is zero.
+func parseTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid time %s", value)
+}